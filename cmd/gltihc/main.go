@@ -6,6 +6,7 @@ import (
 	"image"
 	_ "image/jpeg"
 	"image/png"
+	"io"
 	"math/rand"
 	"os"
 	"path"
@@ -17,12 +18,13 @@ import (
 
 	"github.com/e-asphyx/gltihc/engine"
 	log "github.com/sirupsen/logrus"
-	_ "golang.org/x/image/tiff"
+	"golang.org/x/image/tiff"
 )
 
 type preset struct {
-	filters []string
-	ops     []string
+	filters   []string
+	ops       []string
+	placement string
 }
 
 var presets = map[string]preset{
@@ -54,6 +56,11 @@ var presets = map[string]preset{
 			"add",
 			"mulrgb",
 			"mulycc",
+			"screen",
+			"overlay",
+			"softlight",
+			"darken",
+			"lighten",
 		},
 	},
 	"nocolorshift": {
@@ -79,6 +86,119 @@ var presets = map[string]preset{
 			"xorycc",
 		},
 	},
+	"cascade": {
+		placement: "follow",
+	},
+	"bitwise": {
+		ops: []string{
+			"xorrgb",
+			"xorycc",
+			"andrgb",
+			"andycc",
+			"andraw",
+			"orrgb",
+			"orycc",
+			"orraw",
+			"nandrgb",
+			"nandycc",
+			"nandraw",
+			"subrgbm",
+			"subyccm",
+			"subrawm",
+			"mulrgbm",
+			"mulyccm",
+			"mulrawm",
+			"rotrgb",
+			"rotycc",
+			"rotraw",
+			"shlrgb",
+			"shlycc",
+			"shlraw",
+		},
+	},
+	"blend": {
+		ops: []string{
+			"screen",
+			"overlay",
+			"softlight",
+			"hardlight",
+			"diff",
+			"excl",
+			"sub",
+			"div",
+			"darken",
+			"lighten",
+			"dodge",
+			"burn",
+			"hue",
+			"saturation",
+			"color",
+			"luminosity",
+		},
+	},
+	"lab": {
+		filters: []string{
+			"src",
+			"qlab",
+			"plab",
+			"mixlab",
+		},
+		ops: []string{
+			"cmp",
+			"src",
+			"addlabm",
+		},
+	},
+	"pixsort": {
+		filters: []string{
+			"sort",
+		},
+		ops: []string{
+			"cmp",
+			"src",
+			"darken",
+			"lighten",
+		},
+	},
+	"distort": {
+		filters: []string{
+			"src",
+			"aberr",
+			"wave",
+			"smear",
+		},
+		ops: []string{
+			"cmp",
+			"src",
+			"screen",
+			"lighten",
+		},
+	},
+	"hue": {
+		filters: []string{
+			"src",
+			"huerot",
+			"hsv",
+			"hsl",
+			"phsv",
+			"phsl",
+			"qhsva",
+			"qhsla",
+			"invhsv",
+			"invhsl",
+		},
+		ops: []string{
+			"cmp",
+			"src",
+			"addhuem",
+			"mulsat",
+			"xorval",
+			"hue",
+			"saturation",
+			"color",
+			"luminosity",
+		},
+	},
 }
 
 var funcMap = template.FuncMap{
@@ -111,6 +231,11 @@ func main() {
 		ops      string
 		preset   string
 		dir      string
+		curve    string
+		place    string
+		content  string
+		deep     bool
+		budget   int
 	)
 
 	flag.Usage = func() {
@@ -124,9 +249,10 @@ func main() {
 		sort.Strings(p)
 
 		fmt.Fprintf(flag.CommandLine.Output(),
-			"\nFilters:\n  %s\n\nOperations:\n  %s\n\nPresets:\n  %s\n",
+			"\nFilters:\n  %s\n\nOperations:\n  %s\n\nPlacements:\n  %s\n\nPresets:\n  %s\n",
 			strings.Join(engine.FilterNames(), ", "),
 			strings.Join(engine.OpNames(), ", "),
+			strings.Join(engine.PlacementNames(), ", "),
 			strings.Join(p, ", "))
 	}
 
@@ -138,6 +264,30 @@ func main() {
 	flag.IntVar(&opt.MinFilters, "min-filters", 1, "Minimum filters number in a chain")
 	flag.IntVar(&opt.MaxFilters, "max-filters", 1, "Maximum filters number in a chain")
 	flag.IntVar(&opt.Threads, "threads", 0, "Number of threads")
+	flag.BoolVar(&opt.PadEdges, "pad", false, "Pad partial edge blocks instead of clipping them")
+	flag.IntVar(&opt.PixelShiftX, "shift-x", 0, "Maximum horizontal sub-block shift in pixels")
+	flag.IntVar(&opt.PixelShiftY, "shift-y", 0, "Maximum vertical sub-block shift in pixels")
+	flag.BoolVar(&opt.WrapEdges, "wrap", false, "Wrap shifted source and filter samples around the image edges instead of clamping")
+	flag.IntVar(&opt.FeatherBlock, "feather", 0, "Feather block edges over N pixels")
+	flag.IntVar(&opt.FeatherRun, "feather-run", 0, "Feather the start and the end of a segment over N pixels")
+	flag.StringVar(&curve, "feather-curve", "linear", "Feathering falloff curve: linear, smooth or quad")
+	flag.StringVar(&place, "placement", "uniform", "Segment placement distribution")
+	flag.Float64Var(&opt.PlacementCenter, "placement-center", 0.5, "Placement center relative to image size")
+	flag.Float64Var(&opt.PlacementSpread, "placement-spread", 0.1, "Placement spread relative to image size")
+	flag.IntVar(&opt.PlacementClusters, "clusters", 3, "Number of placement clusters")
+	flag.StringVar(&content, "content", "none", "Prefer segments by block statistics: "+strings.Join(engine.ContentMetricNames(), ", "))
+	flag.BoolVar(&opt.ContentFlat, "content-flat", false, "Prefer flat areas instead of detailed ones")
+	flag.Float64Var(&opt.ContentStrength, "content-strength", 1, "Content preference strength")
+	flag.IntVar(&opt.MinWaveAmp, "min-wave-amp", 0, "Minimum wave filter amplitude in pixels")
+	flag.IntVar(&opt.MaxWaveAmp, "max-wave-amp", 0, "Maximum wave filter amplitude in pixels, 0 for block size relative")
+	flag.Float64Var(&opt.MinWaveFreq, "min-wave-freq", 0, "Minimum wave filter frequency in periods per row")
+	flag.Float64Var(&opt.MaxWaveFreq, "max-wave-freq", 0, "Maximum wave filter frequency in periods per row, 0 for block size relative")
+	flag.Float64Var(&opt.MinOpacity, "min-opacity", 1, "Minimum opacity of the operations in a chain")
+	flag.Float64Var(&opt.MaxOpacity, "max-opacity", 1, "Maximum opacity of the operations in a chain")
+	flag.BoolVar(&deep, "16", false, "Write 16-bit output even for 8-bit input")
+	flag.BoolVar(&opt.LinearLight, "linear", false, "Process in linear light")
+	flag.BoolVar(&opt.LegacyYCC, "legacy-ycc", false, "Use 8-bit YCbCr conversion to reproduce older results")
+	flag.IntVar(&budget, "mem", 0, "Memory budget for the working images in MiB, 0 for no limit")
 	flag.StringVar(&logLevel, "log", "info", "Log level")
 	flag.IntVar(&copies, "copies", 1, "Copies")
 	flag.StringVar(&format, "fmt", "{{.Input | basename}}_{{printf \"%08d\" .CopiesCount}}.png", "Output file name format")
@@ -165,6 +315,9 @@ func main() {
 		}
 		opt.Filters = p.filters
 		opt.Ops = p.ops
+		if p.placement != "" && !isFlagSet("placement") {
+			place = p.placement
+		}
 	} else {
 		if filters != "" {
 			opt.Filters = strings.Split(filters, ",")
@@ -174,6 +327,21 @@ func main() {
 		}
 	}
 
+	if opt.FeatherCurve = engine.GetFeatherCurveID(curve); opt.FeatherCurve < 0 {
+		log.Fatalf("Unknown feathering curve `%s'", curve)
+	}
+
+	if opt.Placement = engine.GetPlacementID(place); opt.Placement < 0 {
+		log.Fatalf("Unknown placement `%s'", place)
+	}
+
+	if opt.Content = engine.GetContentMetricID(content); opt.Content < 0 {
+		log.Fatalf("Unknown content metric `%s'", content)
+	}
+
+	opt.Opacity = true
+	opt.MemoryBudget = int64(budget) << 20
+
 	outTpl, err := template.New("output").Funcs(funcMap).Parse(format)
 	if err != nil {
 		log.Fatal(err)
@@ -187,24 +355,15 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	var eng engine.Engine
+	defer eng.Close()
+
 	inputs := flag.Args()
 	for cnt, infile := range inputs {
 		log.Printf("processing: %s", infile)
 
-		reader, err := os.Open(infile)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		source, _, err := image.Decode(reader)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if err := reader.Close(); err != nil {
-			log.Fatal(err)
-		}
-
+		// With the memory budget the source is decoded again for every copy instead of being kept around
+		var source image.Image
 		var ln int
 		for c := copies - 1; c > 0; c = c / 10 {
 			ln++
@@ -224,30 +383,67 @@ func main() {
 				log.Fatal(err)
 			}
 
-			res, err := opt.Apply(source)
-			if err != nil {
-				log.Fatal(err)
+			img := source
+			if img == nil {
+				if img, err = decode(infile); err != nil {
+					log.Fatal(err)
+				}
+				if budget == 0 {
+					source = img
+				}
 			}
+			opt.Output16 = deep || engine.Is16Bit(img)
 
 			name := outName.String()
 			if dir != "" {
 				name = filepath.Join(dir, name)
 			}
 
-			log.Printf("writing: %s", name)
-			f, err := os.Create(name)
+			err = eng.ApplyFunc(&opt, img, func(res image.Image) error {
+				log.Printf("writing: %s", name)
+				f, err := os.Create(name)
+				if err != nil {
+					return err
+				}
+				if err := encode(f, name, res); err != nil {
+					f.Close()
+					return err
+				}
+				return f.Close()
+			})
 			if err != nil {
 				log.Fatal(err)
 			}
+		}
+	}
+}
 
-			if err := png.Encode(f, res); err != nil {
-				f.Close()
-				log.Fatal(err)
-			}
+func decode(name string) (image.Image, error) {
+	reader, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	img, _, err := image.Decode(reader)
+	return img, err
+}
 
-			if err := f.Close(); err != nil {
-				log.Fatal(err)
-			}
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
+	})
+	return set
+}
+
+// encode picks the format by the file extension. Both PNG and TIFF keep 16-bit images as is
+func encode(w io.Writer, name string, img image.Image) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tif", ".tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	default:
+		return png.Encode(w, img)
 	}
 }
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"sync"
+)
+
+// Chromatic aberration. Red and blue are sampled from the source at opposite offsets while green and alpha
+// stay in place. Red comes from the negative offset, which points towards the image center in the radial mode.
+// The samples may fall outside of the block and are clamped or wrapped at the image edges
+
+const (
+	aberrHorizontal = iota
+	aberrVertical
+	aberrRadial
+	aberrNumModes
+)
+
+var aberrModeNames = [...]string{
+	aberrHorizontal: "h",
+	aberrVertical:   "v",
+	aberrRadial:     "r",
+}
+
+// Largest radial offset relative to the distance from the image center, 1/0x10000 units.
+// Must stay below 0x10000 so the samples of a span fit into twice its length
+const maxAberrScale = 0x500
+
+type filterAberr struct {
+	mode uint8
+	d    int // Offset in pixels or the radial scale
+	wrap bool
+}
+
+type aberrBuffers struct {
+	s, d [spanLen]NRGBA
+	c    [2 * spanLen]NRGBA
+}
+
+var aberrPool = sync.Pool{
+	New: func() interface{} { return new(aberrBuffers) },
+}
+
+func (f filterAberr) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	f.filterBlock(bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterAberr) filterBlock(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	buf := aberrPool.Get().(*aberrBuffers)
+	defer aberrPool.Put(buf)
+	_, replace := op.(opReplace)
+	// Doubled image center
+	cx, cy := src.Rect.Min.X+src.Rect.Max.X, src.Rect.Min.Y+src.Rect.Max.Y
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		sy := sp.Y + y - dr.Min.Y
+		for x := dr.Min.X; x < dr.Max.X; x += spanLen {
+			n := dr.Max.X - x
+			if n > spanLen {
+				n = spanLen
+			}
+			s, c := buf.s[:n], buf.c[:n]
+			sx := sp.X + x - dr.Min.X
+			src.load(s, sx, sy)
+
+			switch f.mode {
+			case aberrHorizontal, aberrVertical:
+				dx, dy := f.d, 0
+				if f.mode == aberrVertical {
+					dx, dy = 0, f.d
+				}
+				src.loadEdge(c, sx-dx, sy-dy, src.Rect, f.wrap)
+				for i := range s {
+					s[i][0] = c[i][0]
+				}
+				src.loadEdge(c, sx+dx, sy+dy, src.Rect, f.wrap)
+				for i := range s {
+					s[i][2] = c[i][2]
+				}
+			default:
+				// The sample positions grow monotonically along the span
+				oy := f.radial(sy, cy)
+				x0, x1 := sx-f.radial(sx, cx), sx+n-1-f.radial(sx+n-1, cx)
+				c = buf.c[:x1-x0+1]
+				src.loadEdge(c, x0, sy-oy, src.Rect, f.wrap)
+				for i := range s {
+					s[i][0] = c[sx+i-f.radial(sx+i, cx)-x0][0]
+				}
+				x0, x1 = sx+f.radial(sx, cx), sx+n-1+f.radial(sx+n-1, cx)
+				c = buf.c[:x1-x0+1]
+				src.loadEdge(c, x0, sy+oy, src.Rect, f.wrap)
+				for i := range s {
+					s[i][2] = c[sx+i+f.radial(sx+i, cx)-x0][2]
+				}
+			}
+			storeOp(dst, x, y, s, buf.d[:n], op, replace)
+		}
+	}
+}
+
+// radial returns the offset of the coordinate v. c is the doubled image center
+func (f filterAberr) radial(v, c int) int {
+	return ((2*v+1-c)*f.d + 0x10000) >> 17
+}
+
+func (f filterAberr) String() string {
+	return fmt.Sprintf("aberr:{m:%s,d:%d,w:%t}", aberrModeNames[f.mode], f.d, f.wrap)
+}
+
+func newFilterAberr(opt *FilterOptions) Filter {
+	f := filterAberr{
+		mode: uint8(rand.Intn(aberrNumModes)),
+		wrap: opt.WrapEdges,
+	}
+	if f.mode == aberrRadial {
+		f.d = 1 + rand.Intn(maxAberrScale)
+	} else {
+		max := opt.BlockSize / 2
+		if max < 1 {
+			max = 1
+		}
+		f.d = 1 + rand.Intn(max)
+	}
+	if rand.Intn(2) == 1 {
+		f.d = -f.d
+	}
+	return f
+}
@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"image"
+	"testing"
+)
+
+func TestAberr(t *testing.T) {
+	// The gradient pixel at x, y is {x*7, y*5, x^y}. The radial offsets of the corners are 3 and 2 pixels
+	r := image.Rect(0, 0, 40, 30)
+	radial := maxAberrScale * 8
+	for _, tt := range []struct {
+		f        filterAberr
+		x, y     int
+		expected NRGBA
+	}{
+		{filterAberr{mode: aberrHorizontal, d: 3}, 10, 5, rgb8(49, 25, 8)},
+		{filterAberr{mode: aberrHorizontal, d: 3}, 15, 5, rgb8(84, 25, 23)},
+		{filterAberr{mode: aberrHorizontal, d: 3}, 1, 5, rgb8(0, 25, 1)},
+		{filterAberr{mode: aberrHorizontal, d: -5, wrap: true}, 2, 5, rgb8(49, 25, 32)},
+		{filterAberr{mode: aberrVertical, d: 2, wrap: true}, 3, 0, rgb8(21, 0, 1)},
+		{filterAberr{mode: aberrVertical, d: -7}, 6, 27, rgb8(42, 135, 18)},
+		// Red comes from the center side like from the negative side of the linear modes
+		{filterAberr{mode: aberrRadial, d: radial}, 0, 0, rgb8(21, 0, 0)},
+		{filterAberr{mode: aberrRadial, d: radial}, 39, 29, rgb8(252, 145, 58)},
+		{filterAberr{mode: aberrRadial, d: -radial, wrap: true}, 0, 0, rgb8(3, 0, 1)},
+	} {
+		_, dst := filterGradient(tt.f, r, 16)
+		if c := pixelAt(dst, tt.x, tt.y); c != tt.expected {
+			t.Errorf("%v: pixel %d,%d is %v, expected %v", tt.f, tt.x, tt.y, c, tt.expected)
+		}
+		// The samples cross the block edges
+		_, ref := filterGradient(tt.f, r, r.Dx())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if c, e := pixelAt(dst, x, y), pixelAt(ref, x, y); c != e {
+					t.Fatalf("%v: pixel %d,%d of the blocks is %v, expected %v", tt.f, x, y, c, e)
+				}
+			}
+		}
+	}
+}
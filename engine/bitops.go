@@ -0,0 +1,121 @@
+package engine
+
+import "fmt"
+
+// Bitwise and modular operations on 16 bit channels. Each one comes in three flavours: RGB, YCbCr and raw.
+// The raw variants read the channels with the bytes swapped and write the result back as is so the low
+// byte of a channel becomes the most significant one
+
+type bitOp uint8
+
+const (
+	bitAnd bitOp = iota
+	bitOr
+	bitNand
+	bitSub // dst - src modulo 0x10000
+	bitMul // src * dst modulo 0x10000
+	bitRot // src rotated left by the 4 most significant bits of dst
+	bitShl // dst shifted left by the 4 most significant bits of src
+)
+
+var bitOpNames = [...]string{
+	bitAnd:  "and%s",
+	bitOr:   "or%s",
+	bitNand: "nand%s",
+	bitSub:  "sub%sm",
+	bitMul:  "mul%sm",
+	bitRot:  "rot%s",
+	bitShl:  "shl%s",
+}
+
+func (k bitOp) apply(s, d uint32) uint32 {
+	switch k {
+	case bitAnd:
+		return s & d
+	case bitOr:
+		return s | d
+	case bitNand:
+		return ^(s & d) & 0xffff
+	case bitSub:
+		return (d - s) & 0xffff
+	case bitMul:
+		return (s * d) & 0xffff
+	case bitRot:
+		n := d >> 12
+		return (s<<n | s>>(16-n)) & 0xffff
+	default:
+		return (d << (s >> 12)) & 0xffff
+	}
+}
+
+func (k bitOp) name(space string) string {
+	return fmt.Sprintf(bitOpNames[k], space)
+}
+
+func swap16(v uint32) uint32 {
+	return (v>>8)&0xff | (v&0xff)<<8
+}
+
+type opBitRGB struct {
+	k bitOp
+}
+
+func (o opBitRGB) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, o.k.apply(src[0], dst[0]), o.k.apply(src[1], dst[1]), o.k.apply(src[2], dst[2]))
+}
+
+func (o opBitRGB) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opBitRGB) String() string { return o.k.name("rgb") }
+
+// opBitYCC treats chroma as signed values in two's complement like opXorYCC does. It always converts in 16 bit
+// and ignores LegacyYCC as there are no earlier 8 bit results of these operations to reproduce
+type opBitYCC struct {
+	k bitOp
+}
+
+func (o opBitYCC) Apply(dst, src NRGBA) NRGBA {
+	sY, sCb, sCr := rgbToYCC(src[0], src[1], src[2])
+	dY, dCb, dCr := rgbToYCC(dst[0], dst[1], dst[2])
+
+	sY = o.k.apply(sY, dY)
+	sCb = o.k.apply(sCb^0x8000, dCb^0x8000) ^ 0x8000
+	sCr = o.k.apply(sCr^0x8000, dCr^0x8000) ^ 0x8000
+	sr, sg, sb := yccToRGB(sY, sCb, sCr)
+
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opBitYCC) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opBitYCC) String() string { return o.k.name("ycc") }
+
+type opBitRaw struct {
+	k bitOp
+}
+
+func (o opBitRaw) Apply(dst, src NRGBA) NRGBA {
+	sr := o.k.apply(swap16(src[0]), swap16(dst[0]))
+	sg := o.k.apply(swap16(src[1]), swap16(dst[1]))
+	sb := o.k.apply(swap16(src[2]), swap16(dst[2]))
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opBitRaw) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opBitRaw) String() string { return o.k.name("raw") }
@@ -0,0 +1,421 @@
+package engine
+
+// Standard blend modes. The source is the blend layer and the destination is the backdrop.
+// All values are 16 bit with 0xffff representing 1.0
+
+// blendCompose puts the blended color sr, sg, sb with the source alpha over dst
+func blendCompose(dst, src NRGBA, sr, sg, sb uint32) NRGBA {
+	if src[3] == 0xffff {
+		return NRGBA{sr, sg, sb, src[3]}
+	}
+
+	// Compose
+	sr = (sr * src[3]) / 0xffff
+	sg = (sg * src[3]) / 0xffff
+	sb = (sb * src[3]) / 0xffff
+
+	dr := (dst[0] * dst[3]) / 0xffff
+	dg := (dst[1] * dst[3]) / 0xffff
+	db := (dst[2] * dst[3]) / 0xffff
+
+	r := sr + dr*(0xffff-src[3])/0xffff
+	g := sg + dg*(0xffff-src[3])/0xffff
+	b := sb + db*(0xffff-src[3])/0xffff
+	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+
+	if a != 0 {
+		r = (r * 0xffff) / a
+		g = (g * 0xffff) / a
+		b = (b * 0xffff) / a
+	}
+
+	return NRGBA{r, g, b, a}
+}
+
+func blendScreen(s, d uint32) uint32 {
+	return 0xffff - (0xffff-s)*(0xffff-d)/0xffff
+}
+
+func blendHardLight(s, d uint32) uint32 {
+	if s < 0x8000 {
+		return d * (s << 1) / 0xffff
+	}
+	return 0xffff - (0xffff-d)*((0xffff-s)<<1)/0xffff
+}
+
+// Pegtop's formula, continuous and free of square roots
+func blendSoftLight(s, d uint32) uint32 {
+	return d*d/0xffff + (s<<1)*(d*(0xffff-d)/0xffff)/0xffff
+}
+
+func blendDifference(s, d uint32) uint32 {
+	if s > d {
+		return s - d
+	}
+	return d - s
+}
+
+func blendExclusion(s, d uint32) uint32 {
+	return s*(0xffff-d)/0xffff + d*(0xffff-s)/0xffff
+}
+
+func blendSubtract(s, d uint32) uint32 {
+	if s > d {
+		return 0
+	}
+	return d - s
+}
+
+func blendDivide(s, d uint32) uint32 {
+	if s <= d {
+		if d == 0 {
+			return 0
+		}
+		return 0xffff
+	}
+	return d * 0xffff / s
+}
+
+func blendDarken(s, d uint32) uint32 {
+	if s < d {
+		return s
+	}
+	return d
+}
+
+func blendLighten(s, d uint32) uint32 {
+	if s > d {
+		return s
+	}
+	return d
+}
+
+func blendDodge(s, d uint32) uint32 {
+	if d == 0 {
+		return 0
+	}
+	if s+d >= 0xffff {
+		return 0xffff
+	}
+	return d * 0xffff / (0xffff - s)
+}
+
+func blendBurn(s, d uint32) uint32 {
+	if d == 0xffff {
+		return 0xffff
+	}
+	if s+d <= 0xffff {
+		return 0
+	}
+	return 0xffff - (0xffff-d)*0xffff/s
+}
+
+type opScreen struct{}
+
+func (o opScreen) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendScreen(src[0], dst[0]), blendScreen(src[1], dst[1]), blendScreen(src[2], dst[2]))
+}
+
+func (o opScreen) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opScreen) String() string { return "screen" }
+
+// opOverlay is the hard light with the layers swapped
+type opOverlay struct{}
+
+func (o opOverlay) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendHardLight(dst[0], src[0]), blendHardLight(dst[1], src[1]), blendHardLight(dst[2], src[2]))
+}
+
+func (o opOverlay) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opOverlay) String() string { return "overlay" }
+
+type opSoftLight struct{}
+
+func (o opSoftLight) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendSoftLight(src[0], dst[0]), blendSoftLight(src[1], dst[1]), blendSoftLight(src[2], dst[2]))
+}
+
+func (o opSoftLight) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opSoftLight) String() string { return "softlight" }
+
+type opHardLight struct{}
+
+func (o opHardLight) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendHardLight(src[0], dst[0]), blendHardLight(src[1], dst[1]), blendHardLight(src[2], dst[2]))
+}
+
+func (o opHardLight) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opHardLight) String() string { return "hardlight" }
+
+type opDifference struct{}
+
+func (o opDifference) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendDifference(src[0], dst[0]), blendDifference(src[1], dst[1]), blendDifference(src[2], dst[2]))
+}
+
+func (o opDifference) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opDifference) String() string { return "diff" }
+
+type opExclusion struct{}
+
+func (o opExclusion) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendExclusion(src[0], dst[0]), blendExclusion(src[1], dst[1]), blendExclusion(src[2], dst[2]))
+}
+
+func (o opExclusion) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opExclusion) String() string { return "excl" }
+
+type opSubtract struct{}
+
+func (o opSubtract) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendSubtract(src[0], dst[0]), blendSubtract(src[1], dst[1]), blendSubtract(src[2], dst[2]))
+}
+
+func (o opSubtract) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opSubtract) String() string { return "sub" }
+
+type opDivide struct{}
+
+func (o opDivide) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendDivide(src[0], dst[0]), blendDivide(src[1], dst[1]), blendDivide(src[2], dst[2]))
+}
+
+func (o opDivide) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opDivide) String() string { return "div" }
+
+type opDarken struct{}
+
+func (o opDarken) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendDarken(src[0], dst[0]), blendDarken(src[1], dst[1]), blendDarken(src[2], dst[2]))
+}
+
+func (o opDarken) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opDarken) String() string { return "darken" }
+
+type opLighten struct{}
+
+func (o opLighten) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendLighten(src[0], dst[0]), blendLighten(src[1], dst[1]), blendLighten(src[2], dst[2]))
+}
+
+func (o opLighten) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opLighten) String() string { return "lighten" }
+
+type opDodge struct{}
+
+func (o opDodge) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendDodge(src[0], dst[0]), blendDodge(src[1], dst[1]), blendDodge(src[2], dst[2]))
+}
+
+func (o opDodge) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opDodge) String() string { return "dodge" }
+
+type opBurn struct{}
+
+func (o opBurn) Apply(dst, src NRGBA) NRGBA {
+	return blendCompose(dst, src, blendBurn(src[0], dst[0]), blendBurn(src[1], dst[1]), blendBurn(src[2], dst[2]))
+}
+
+func (o opBurn) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opBurn) String() string { return "burn" }
+
+// Non-separable blend modes as defined by the W3C compositing spec. Colors are kept signed
+// until setLum clips them back into range
+
+type rgb64 [3]int64
+
+func toRGB64(c NRGBA) rgb64 {
+	return rgb64{int64(c[0]), int64(c[1]), int64(c[2])}
+}
+
+func (c rgb64) lum() int64 {
+	return (19661*c[0] + 38666*c[1] + 7209*c[2] + 0x8000) >> 16
+}
+
+func (c rgb64) sat() int64 {
+	return int64(max3(uint32(c[0]), uint32(c[1]), uint32(c[2])) - min3(uint32(c[0]), uint32(c[1]), uint32(c[2])))
+}
+
+func (c rgb64) setSat(s int64) rgb64 {
+	lo, mid, hi := 0, 1, 2
+	if c[lo] > c[mid] {
+		lo, mid = mid, lo
+	}
+	if c[mid] > c[hi] {
+		mid, hi = hi, mid
+	}
+	if c[lo] > c[mid] {
+		lo, mid = mid, lo
+	}
+	var ret rgb64
+	if c[hi] > c[lo] {
+		ret[mid] = (c[mid] - c[lo]) * s / (c[hi] - c[lo])
+		ret[hi] = s
+	}
+	return ret
+}
+
+func (c rgb64) setLum(l int64) (uint32, uint32, uint32) {
+	d := l - c.lum()
+	c = rgb64{c[0] + d, c[1] + d, c[2] + d}
+
+	// Clip
+	l = c.lum()
+	lo, hi := c[0], c[0]
+	for _, v := range c[1:] {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	for i, v := range c {
+		if lo < 0 && l > lo {
+			v = l + (v-l)*l/(l-lo)
+		}
+		if hi > 0xffff && hi > l {
+			v = l + (v-l)*(0xffff-l)/(hi-l)
+		}
+		c[i] = int64(clamp16(v))
+	}
+	return uint32(c[0]), uint32(c[1]), uint32(c[2])
+}
+
+type opHue struct{}
+
+func (o opHue) Apply(dst, src NRGBA) NRGBA {
+	d := toRGB64(dst)
+	sr, sg, sb := toRGB64(src).setSat(d.sat()).setLum(d.lum())
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opHue) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opHue) String() string { return "hue" }
+
+type opSaturation struct{}
+
+func (o opSaturation) Apply(dst, src NRGBA) NRGBA {
+	d := toRGB64(dst)
+	sr, sg, sb := d.setSat(toRGB64(src).sat()).setLum(d.lum())
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opSaturation) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opSaturation) String() string { return "saturation" }
+
+type opColor struct{}
+
+func (o opColor) Apply(dst, src NRGBA) NRGBA {
+	sr, sg, sb := toRGB64(src).setLum(toRGB64(dst).lum())
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opColor) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opColor) String() string { return "color" }
+
+type opLuminosity struct{}
+
+func (o opLuminosity) Apply(dst, src NRGBA) NRGBA {
+	sr, sg, sb := toRGB64(dst).setLum(toRGB64(src).lum())
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opLuminosity) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
+func (o opLuminosity) String() string { return "luminosity" }
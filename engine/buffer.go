@@ -0,0 +1,398 @@
+package engine
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// buffer is a working image in either image.NRGBA64 (8 bytes per pixel) or image.NRGBA (4 bytes per pixel) layout.
+// Filters and operations see the pixels as 16 bit NRGBA values in both cases. If pages is set the pixels
+// are kept in the stripe store and Pix is unused
+type buffer struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+	bpp    int
+	pages  *stripeStore
+}
+
+func bufferNRGBA64(img *image.NRGBA64) *buffer {
+	return &buffer{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect, bpp: 8}
+}
+
+func (b *buffer) nrgba64() *image.NRGBA64 {
+	return &image.NRGBA64{Pix: b.Pix, Stride: b.Stride, Rect: b.Rect}
+}
+
+func (b *buffer) nrgba() *image.NRGBA {
+	return &image.NRGBA{Pix: b.Pix, Stride: b.Stride, Rect: b.Rect}
+}
+
+func (b *buffer) reuse(r image.Rectangle, bpp int) *buffer {
+	n := r.Dx() * r.Dy() * bpp
+	if cap(b.Pix) < n {
+		b.Pix = make([]uint8, n)
+	}
+	b.Pix = b.Pix[:n]
+	b.Stride = r.Dx() * bpp
+	b.Rect = r
+	b.bpp = bpp
+	b.pages = nil
+	return b
+}
+
+// spill moves the buffer to a stripe store using up to budget bytes of memory
+func (b *buffer) spill(r image.Rectangle, bpp int, budget int64) error {
+	pages, err := newStripeStore(r.Dx()*bpp, r.Dy(), budget)
+	if err != nil {
+		return err
+	}
+	b.Pix = nil
+	b.Stride = r.Dx() * bpp
+	b.Rect = r
+	b.bpp = bpp
+	b.pages = pages
+	return nil
+}
+
+// release closes the stripe store if any
+func (b *buffer) release() error {
+	if b.pages == nil {
+		return nil
+	}
+	err := b.pages.err
+	if e := b.pages.close(); err == nil {
+		err = e
+	}
+	b.pages = nil
+	return err
+}
+
+// row returns the pixels of the row y. It must not be called concurrently with other accessors
+func (b *buffer) row(y int, write bool) []uint8 {
+	if b.pages != nil {
+		return b.pages.row(y-b.Rect.Min.Y, write)
+	}
+	i := (y - b.Rect.Min.Y) * b.Stride
+	n := b.Rect.Dx() * b.bpp
+	return b.Pix[i : i+n : i+n]
+}
+
+func (b *buffer) offset(x, y int) int {
+	return (y-b.Rect.Min.Y)*b.Stride + (x-b.Rect.Min.X)*b.bpp
+}
+
+// load reads len(s) pixels starting at (x, y)
+func (b *buffer) load(s []NRGBA, x, y int) {
+	if b.pages != nil {
+		set := b.pages.set(y - b.Rect.Min.Y)
+		set.mtx.Lock()
+		b.loadPix(s, b.pages.row(y-b.Rect.Min.Y, false)[(x-b.Rect.Min.X)*b.bpp:])
+		set.mtx.Unlock()
+		return
+	}
+	b.loadPix(s, b.Pix[b.offset(x, y):])
+}
+
+// store writes len(s) pixels starting at (x, y)
+func (b *buffer) store(s []NRGBA, x, y int) {
+	if b.pages != nil {
+		set := b.pages.set(y - b.Rect.Min.Y)
+		set.mtx.Lock()
+		b.storePix(b.pages.row(y-b.Rect.Min.Y, true)[(x-b.Rect.Min.X)*b.bpp:], s)
+		set.mtx.Unlock()
+		return
+	}
+	b.storePix(b.Pix[b.offset(x, y):], s)
+}
+
+// growPixels returns s resized to n pixels. If it has to reallocate it reserves the capacity for reserve pixels
+func growPixels(s []NRGBA, n, reserve int) []NRGBA {
+	if cap(s) < n {
+		if reserve < n {
+			reserve = n
+		}
+		s = make([]NRGBA, reserve)
+	}
+	return s[:n]
+}
+
+// loadEdge reads len(s) pixels starting at (x, y) which may lie outside of the area r of the buffer.
+// The coordinates are wrapped around or clamped to the edges of r
+func (b *buffer) loadEdge(s []NRGBA, x, y int, r image.Rectangle, wrapEdges bool) {
+	if wrapEdges {
+		y = r.Min.Y + wrap(y-r.Min.Y, r.Dy())
+	} else if y < r.Min.Y {
+		y = r.Min.Y
+	} else if y >= r.Max.Y {
+		y = r.Max.Y - 1
+	}
+	for len(s) != 0 {
+		if wrapEdges {
+			x = r.Min.X + wrap(x-r.Min.X, r.Dx())
+		}
+		var n int
+		switch {
+		case x < r.Min.X:
+			n = r.Min.X - x
+			if n > len(s) {
+				n = len(s)
+			}
+			b.load(s[:1], r.Min.X, y)
+		case x >= r.Max.X:
+			n = len(s)
+			b.load(s[:1], r.Max.X-1, y)
+		default:
+			n = r.Max.X - x
+			if n > len(s) {
+				n = len(s)
+			}
+			b.load(s[:n], x, y)
+		}
+		if x < r.Min.X || x >= r.Max.X {
+			for i := 1; i < n; i++ {
+				s[i] = s[0]
+			}
+		}
+		s = s[n:]
+		x += n
+	}
+}
+
+func (b *buffer) loadPix(s []NRGBA, pix []uint8) {
+	if b.bpp == 8 {
+		loadSpan(s, pix)
+	} else {
+		loadSpan8(s, pix)
+	}
+}
+
+func (b *buffer) storePix(pix []uint8, s []NRGBA) {
+	if b.bpp == 8 {
+		storeSpan(pix, s)
+	} else {
+		storeSpan8(pix, s)
+	}
+}
+
+func loadSpan(s []NRGBA, pix []uint8) {
+	pix = pix[:len(s)<<3]
+	for i := range s {
+		v := binary.BigEndian.Uint64(pix[i<<3:])
+		s[i] = NRGBA{uint32(v >> 48), uint32(v>>32) & 0xffff, uint32(v>>16) & 0xffff, uint32(v) & 0xffff}
+	}
+}
+
+func storeSpan(pix []uint8, s []NRGBA) {
+	pix = pix[:len(s)<<3]
+	for i, c := range s {
+		v := uint64(c[0]&0xffff)<<48 | uint64(c[1]&0xffff)<<32 | uint64(c[2]&0xffff)<<16 | uint64(c[3]&0xffff)
+		binary.BigEndian.PutUint64(pix[i<<3:], v)
+	}
+}
+
+func loadSpan8(s []NRGBA, pix []uint8) {
+	pix = pix[:len(s)<<2]
+	for i := range s {
+		v := binary.BigEndian.Uint32(pix[i<<2:])
+		s[i] = NRGBA{(v >> 24) * 0x101, (v >> 16 & 0xff) * 0x101, (v >> 8 & 0xff) * 0x101, (v & 0xff) * 0x101}
+	}
+}
+
+// to8 rounds the 16 bit value to 8 bits. Truncating would drift downwards on every pass through an 8 bit buffer
+func to8(c uint32) uint32 {
+	return ((c & 0xffff) + 0x80) / 0x101
+}
+
+// round8 rounds the big endian 16 bit value hi:lo to 8 bits
+func round8(hi, lo uint8) uint8 {
+	return uint8(to8(uint32(hi)<<8 | uint32(lo)))
+}
+
+func storeSpan8(pix []uint8, s []NRGBA) {
+	pix = pix[:len(s)<<2]
+	for i, c := range s {
+		v := to8(c[0])<<24 | to8(c[1])<<16 | to8(c[2])<<8 | to8(c[3])
+		binary.BigEndian.PutUint32(pix[i<<2:], v)
+	}
+}
+
+// copyRows copies the rows y0..y1 from src of the same layout
+func (b *buffer) copyRows(src *buffer, y0, y1 int) {
+	for y := y0; y < y1; y++ {
+		copy(b.row(y, true), src.row(y, false))
+	}
+}
+
+// mapColor replaces the color components of the rows y0..y1 of a 16 bit buffer using the table
+func (b *buffer) mapColor(y0, y1 int, table []uint16) {
+	for y := y0; y < y1; y++ {
+		row := b.row(y, true)
+		for i := 0; i < len(row); i += 8 {
+			for j := i; j < i+6; j += 2 {
+				v := table[uint32(row[j])<<8|uint32(row[j+1])]
+				row[j], row[j+1] = uint8(v>>8), uint8(v)
+			}
+		}
+	}
+}
+
+// mapSpan replaces the color components of the pixels using the table
+func mapSpan(s []NRGBA, table []uint16) {
+	for i, c := range s {
+		s[i] = NRGBA{uint32(table[c[0]&0xffff]), uint32(table[c[1]&0xffff]), uint32(table[c[2]&0xffff]), c[3]}
+	}
+}
+
+// padEdges replicates the last column and row of the w*h area into the padding
+func (b *buffer) padEdges(w, h int) {
+	for y := 0; y < h; y++ {
+		row := b.row(y, true)
+		edge := row[(w-1)*b.bpp : w*b.bpp]
+		for i := w * b.bpp; i < len(row); i += b.bpp {
+			copy(row[i:i+b.bpp], edge)
+		}
+	}
+	for y := h; y < b.Rect.Dy(); y++ {
+		copy(b.row(y, true), b.row(h-1, false))
+	}
+}
+
+// Is16Bit reports whether the image carries more than 8 bits per channel
+func Is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return true
+	}
+	switch img.ColorModel() {
+	case color.NRGBA64Model, color.RGBA64Model, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// loadImage converts the area r of the source image to the buffer which must be at least of the same size
+func (b *buffer) loadImage(img image.Image, r image.Rectangle) {
+	w := r.Dx()
+	for y := 0; y < r.Dy(); y++ {
+		dp := b.row(b.Rect.Min.Y+y, true)[:w*b.bpp]
+		switch src := img.(type) {
+		case *image.NRGBA64:
+			if b.bpp == 8 {
+				copy(dp, src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):])
+				continue
+			}
+		case *image.NRGBA:
+			sp := src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):]
+			if b.bpp == 4 {
+				copy(dp, sp)
+				continue
+			}
+			for x := 0; x < w; x++ {
+				s := sp[x*4 : x*4+4 : x*4+4]
+				d := dp[x*8 : x*8+8 : x*8+8]
+				d[0], d[1] = s[0], s[0]
+				d[2], d[3] = s[1], s[1]
+				d[4], d[5] = s[2], s[2]
+				d[6], d[7] = s[3], s[3]
+			}
+			continue
+		}
+
+		// Generic conversion row by row
+		draw.Draw(b.rowImage(dp, w), image.Rect(0, 0, w, 1), img, image.Pt(r.Min.X, r.Min.Y+y), draw.Src)
+	}
+}
+
+func (b *buffer) rowImage(pix []uint8, w int) draw.Image {
+	if b.bpp == 8 {
+		return &image.NRGBA64{Pix: pix, Stride: len(pix), Rect: image.Rect(0, 0, w, 1)}
+	}
+	return &image.NRGBA{Pix: pix, Stride: len(pix), Rect: image.Rect(0, 0, w, 1)}
+}
+
+// storeImage writes the buffer to the area r of dst
+func (b *buffer) storeImage(dst draw.Image, r image.Rectangle) {
+	w := r.Dx()
+	for y := 0; y < r.Dy(); y++ {
+		sp := b.row(b.Rect.Min.Y+y, false)[:w*b.bpp]
+		switch d := dst.(type) {
+		case *image.NRGBA64:
+			if b.bpp == 8 {
+				copy(d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):], sp)
+				continue
+			}
+		case *image.NRGBA:
+			dp := d.Pix[d.PixOffset(r.Min.X, r.Min.Y+y):]
+			if b.bpp == 4 {
+				copy(dp, sp)
+				continue
+			}
+			for x := 0; x < w; x++ {
+				s := sp[x*8 : x*8+8 : x*8+8]
+				o := dp[x*4 : x*4+4 : x*4+4]
+				o[0], o[1], o[2], o[3] = round8(s[0], s[1]), round8(s[2], s[3]), round8(s[4], s[5]), round8(s[6], s[7])
+			}
+			continue
+		}
+
+		draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y+y, r.Max.X, r.Min.Y+y+1), b.rowImage(sp, w), image.Point{}, draw.Src)
+	}
+}
+
+// bufferImage is a read only view of the w*h area at the buffer origin. It caches the last row read
+// so the encoders scanning the image row by row load every row once. It's not safe for concurrent use
+type bufferImage struct {
+	b    *buffer
+	r    image.Rectangle
+	deep bool // Return 16 bit colors
+	y    int
+	row  []NRGBA
+}
+
+func (v *bufferImage) ColorModel() color.Model {
+	if v.deep {
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+func (v *bufferImage) Bounds() image.Rectangle { return v.r }
+
+func (v *bufferImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(v.r) {
+		if v.deep {
+			return color.NRGBA64{}
+		}
+		return color.NRGBA{}
+	}
+	if v.row == nil || v.y != y {
+		if v.row == nil {
+			v.row = make([]NRGBA, v.r.Dx())
+		}
+		v.b.load(v.row, v.b.Rect.Min.X, v.b.Rect.Min.Y+y)
+		v.y = y
+	}
+	c := v.row[x]
+	if v.deep {
+		return color.NRGBA64{uint16(c[0]), uint16(c[1]), uint16(c[2]), uint16(c[3])}
+	}
+	return color.NRGBA{uint8(to8(c[0])), uint8(to8(c[1])), uint8(to8(c[2])), uint8(to8(c[3]))}
+}
+
+// image returns the w*h area at the buffer origin without copying it
+func (b *buffer) image(w, h int, deep bool) image.Image {
+	r := image.Rect(0, 0, w, h)
+	if b.pages == nil && b.Rect.Min == (image.Point{}) {
+		if deep && b.bpp == 8 {
+			return b.nrgba64().SubImage(r)
+		}
+		if !deep && b.bpp == 4 {
+			return b.nrgba().SubImage(r)
+		}
+	}
+	return &bufferImage{b: b, r: r, deep: deep}
+}
@@ -0,0 +1,20 @@
+package engine
+
+import "testing"
+
+func TestStoreSpan8(t *testing.T) {
+	var pix [4]uint8
+	for c := uint32(0); c <= 0xffff; c++ {
+		storeSpan8(pix[:], []NRGBA{{c, c, c, c}})
+		// Nearest 8 bit value
+		expected := uint8(c / 0x101)
+		if c%0x101 > 0x80 {
+			expected++
+		}
+		for i, v := range pix {
+			if v != expected {
+				t.Fatalf("%#x: component %d is %d, expected %d", c, i, v, expected)
+			}
+		}
+	}
+}
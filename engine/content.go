@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	ContentNone = iota
+	ContentVariance
+	ContentEdges
+	ContentEntropy
+	ContentNumMetrics
+)
+
+var contentNames = map[string]int{
+	"none":     ContentNone,
+	"variance": ContentVariance,
+	"edges":    ContentEdges,
+	"entropy":  ContentEntropy,
+}
+
+func GetContentMetricID(name string) int {
+	if id, ok := contentNames[name]; ok {
+		return id
+	}
+	return -1
+}
+
+func ContentMetricNames() []string {
+	ret := make([]string, 0, len(contentNames))
+	for name := range contentNames {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// luma returns the 16 bit luminance of the first pixel of p
+func luma(p []uint8, bpp int) uint32 {
+	var r, g, b uint32
+	if bpp == 8 {
+		r = (uint32(p[0]) << 8) | uint32(p[1])
+		g = (uint32(p[2]) << 8) | uint32(p[3])
+		b = (uint32(p[4]) << 8) | uint32(p[5])
+	} else {
+		r, g, b = uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101
+	}
+	return (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+}
+
+func blockVariance(img *buffer, r image.Rectangle) float64 {
+	var sum, sum2 uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p := img.row(y, false)
+		i := (r.Min.X - img.Rect.Min.X) * img.bpp
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v := uint64(luma(p[i:], img.bpp))
+			sum += v
+			sum2 += v * v
+			i += img.bpp
+		}
+	}
+	n := uint64(r.Dx() * r.Dy())
+	mean := float64(sum) / float64(n)
+	return math.Sqrt(math.Max(float64(sum2)/float64(n)-float64(mean*mean), 0))
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func blockEdges(img *buffer, r image.Rectangle) float64 {
+	var sum uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p := img.row(y, false)
+		var next []uint8
+		if y+1 < r.Max.Y {
+			next = img.row(y+1, false)
+		}
+		i := (r.Min.X - img.Rect.Min.X) * img.bpp
+		for x := r.Min.X; x < r.Max.X; x++ {
+			v := luma(p[i:], img.bpp)
+			if x+1 < r.Max.X {
+				sum += uint64(absDiff(v, luma(p[i+img.bpp:], img.bpp)))
+			}
+			if next != nil {
+				sum += uint64(absDiff(v, luma(next[i:], img.bpp)))
+			}
+			i += img.bpp
+		}
+	}
+	return float64(sum) / float64(r.Dx()*r.Dy())
+}
+
+func blockEntropy(img *buffer, r image.Rectangle) float64 {
+	var hist [256]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p := img.row(y, false)
+		i := (r.Min.X - img.Rect.Min.X) * img.bpp
+		for x := r.Min.X; x < r.Max.X; x++ {
+			hist[luma(p[i:], img.bpp)>>8]++
+			i += img.bpp
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+	var e float64
+	for _, h := range hist {
+		if h != 0 {
+			p := float64(h) / n
+			e -= float64(p * log2(p))
+		}
+	}
+	return e
+}
+
+// blockScores returns the per block metric normalized to 0..1
+func blockScores(img *buffer, metric, blocksX, blocks, blockSize int) []float64 {
+	var fn func(img *buffer, r image.Rectangle) float64
+	switch metric {
+	case ContentVariance:
+		fn = blockVariance
+	case ContentEdges:
+		fn = blockEdges
+	case ContentEntropy:
+		fn = blockEntropy
+	default:
+		return nil
+	}
+
+	scores := make([]float64, blocks)
+	var max float64
+	for b := range scores {
+		scores[b] = fn(img, blockRect(b, blocksX, blockSize, img.Rect))
+		if scores[b] > max {
+			max = scores[b]
+		}
+	}
+	if max != 0 {
+		for b := range scores {
+			scores[b] /= max
+		}
+	}
+	return scores
+}
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestBlockScores(t *testing.T) {
+	// Flat left half, detailed right half
+	src := gradient(image.Rect(0, 0, 64, 32))
+	draw.Draw(src, image.Rect(0, 0, 32, 32), image.Black, image.Point{}, draw.Src)
+	img := image.NewNRGBA64(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+
+	for name, metric := range contentNames {
+		if metric == ContentNone {
+			continue
+		}
+		scores := blockScores(bufferNRGBA64(img), metric, 4, 8, 16)
+		for _, b := range []int{0, 1, 4, 5} {
+			if scores[b] != 0 {
+				t.Errorf("%s: flat block %d has score %f", name, b, scores[b])
+			}
+		}
+		for _, b := range []int{2, 3, 6, 7} {
+			if scores[b] == 0 {
+				t.Errorf("%s: detailed block %d has zero score", name, b)
+			}
+		}
+	}
+}
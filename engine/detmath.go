@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+)
+
+// The functions below replace math.Log2, math.Pow, math.Sin and rand.NormFloat64 where the results affect the output.
+// The math package uses assembly on some architectures so its results may differ in the last bits.
+// These use basic arithmetic only, and explicit float64 conversions keep the compiler from fusing
+// multiply-adds, so they give the same results everywhere
+
+const ln2 = 0.693147180559945309417232121458176568
+
+// log2 returns the binary logarithm of x > 0
+func log2(x float64) float64 {
+	m, e := math.Frexp(x)
+	if m < math.Sqrt2/2 {
+		m *= 2
+		e--
+	}
+	// ln(m) = 2*atanh(t)
+	t := (m - 1) / (m + 1)
+	t2 := float64(t * t)
+	s := 1.0 / 17
+	for k := 15; k >= 1; k -= 2 {
+		s = float64(s*t2) + 1/float64(k)
+	}
+	return float64(e) + float64(2*t*s)/ln2
+}
+
+// exp2 returns 2**y
+func exp2(y float64) float64 {
+	n := math.Floor(y)
+	f := float64((y - n) * ln2)
+	s := 1.0
+	for k := 18; k >= 1; k-- {
+		s = float64(s*f)/float64(k) + 1
+	}
+	return math.Ldexp(s, int(n))
+}
+
+// pow returns x**y for x >= 0 and y >= 0
+func pow(x, y float64) float64 {
+	if y == 0 {
+		return 1
+	}
+	if x <= 0 {
+		return 0
+	}
+	return exp2(float64(y * log2(x)))
+}
+
+// cbrt returns the cube root of x
+func cbrt(x float64) float64 {
+	if x == 0 {
+		return 0
+	}
+	if x < 0 {
+		return -cbrt(-x)
+	}
+	// x = m * 2**e
+	bits := math.Float64bits(x)
+	if bits>>52 == 0 {
+		// Subnormal
+		return cbrt(x*(1<<54)) / (1 << 18)
+	}
+	e := int(bits>>52) - 1022
+	m := math.Float64frombits(bits&(1<<52-1) | 1022<<52)
+	for e%3 != 0 {
+		m /= 2
+		e++
+	}
+	// m is in [1/8, 1). Start with a cubic fit within 2% and refine with Halley's method
+	y := 0.3606803095154908 + float64(m*(1.3158674953761116+float64(m*(-1.1116561697948437+float64(0.43891430243509905*m)))))
+	for i := 0; i < 2; i++ {
+		y3 := float64(float64(y*y) * y)
+		y = float64(y*(y3+2*m)) / (2*y3 + m)
+	}
+	// Exact scaling by a power of two
+	return y * math.Float64frombits(uint64(1023+e/3)<<52)
+}
+
+// sinTurn returns sin(2*Pi*t)
+func sinTurn(t float64) float64 {
+	t -= math.Floor(t)
+	sign := 1.0
+	if t >= 0.5 {
+		t -= 0.5
+		sign = -1
+	}
+	if t > 0.25 {
+		t = 0.5 - t
+	}
+	// Taylor series on [0, Pi/2]
+	x := float64(t * 2 * math.Pi)
+	x2 := float64(x * x)
+	s := 1.0
+	for k := 18; k >= 2; k -= 2 {
+		s = 1 - float64(float64(s*x2)/float64(k*(k+1)))
+	}
+	return sign * float64(s*x)
+}
+
+// normal returns a normally distributed value with zero mean and unit deviation
+func normal() float64 {
+	// 1-Float64 is in (0, 1] so the logarithm is finite
+	return boxMuller(1-rand.Float64(), rand.Float64())
+}
+
+// boxMuller maps the uniform values u1 in (0, 1] and u2 in [0, 1) to a normally distributed one.
+// The tails reach 8.6 deviations at the smallest u1 of 2**-53
+func boxMuller(u1, u2 float64) float64 {
+	r := math.Sqrt(float64(-2 * ln2 * log2(u1)))
+	return float64(r * sinTurn(u2+0.25))
+}
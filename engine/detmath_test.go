@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDetMath(t *testing.T) {
+	for x := 1e-6; x < 1e6; x *= 1.37 {
+		if got, want := log2(x), math.Log2(x); math.Abs(got-want) > 1e-12*math.Max(1, math.Abs(want)) {
+			t.Errorf("log2(%g) = %g, expected %g", x, got, want)
+		}
+	}
+	for y := -50.0; y < 50; y += 0.173 {
+		if got, want := exp2(y), math.Exp2(y); math.Abs(got-want) > 1e-13*want {
+			t.Errorf("exp2(%g) = %g, expected %g", y, got, want)
+		}
+	}
+	for _, x := range []float64{5e-324, 1e-310, 1e-300, 1e300, math.MaxFloat64} {
+		if got, want := cbrt(x), math.Cbrt(x); math.Abs(got-want) > 1e-14*want {
+			t.Errorf("cbrt(%g) = %g, expected %g", x, got, want)
+		}
+	}
+	for x := -1e3; x < 1e3; x += 0.37 {
+		if got, want := cbrt(x), math.Cbrt(x); math.Abs(got-want) > 1e-14*math.Max(1, math.Abs(want)) {
+			t.Errorf("cbrt(%g) = %g, expected %g", x, got, want)
+		}
+	}
+	for x := -3.0; x < 3; x += 0.0137 {
+		if got, want := sinTurn(x), math.Sin(2*math.Pi*x); math.Abs(got-want) > 1e-14 {
+			t.Errorf("sinTurn(%g) = %g, expected %g", x, got, want)
+		}
+	}
+	for x := 0.0; x <= 1; x += 0.01 {
+		for _, y := range []float64{0, 0.5, 1, 1.5, 3} {
+			if got, want := pow(x, y), math.Pow(x, y); math.Abs(got-want) > 1e-13 {
+				t.Errorf("pow(%g, %g) = %g, expected %g", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestNormal(t *testing.T) {
+	if z := boxMuller(0x1p-53, 0); z < 8.5 {
+		t.Errorf("largest sample is %g", z)
+	}
+
+	const n = 100000
+	var sum, sum2 float64
+	tail := 0
+	for i := 0; i < n; i++ {
+		z := normal()
+		sum += z
+		sum2 += z * z
+		if math.Abs(z) > 3 {
+			tail++
+		}
+	}
+	mean := sum / n
+	if v := sum2/n - mean*mean; math.Abs(mean) > 0.02 || math.Abs(v-1) > 0.03 {
+		t.Errorf("mean %g, variance %g", mean, v)
+	}
+	// P(|z| > 3) is 0.0027
+	if p := float64(tail) / n; p < 0.0018 || p > 0.0036 {
+		t.Errorf("%g of the samples are beyond 3 deviations", p)
+	}
+}
@@ -1,8 +1,16 @@
 package engine
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func BenchmarkEngine(b *testing.B) {
@@ -26,3 +34,725 @@ func BenchmarkEngine(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkEngineReuse(b *testing.B) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1000, 1000))
+
+	opt := Options{
+		MinIterations:  10,
+		MaxIterations:  10,
+		BlockSize:      16,
+		MinSegmentSize: 1,
+		MaxSegmentSize: 1,
+		MinFilters:     4,
+		MaxFilters:     4,
+		Threads:        1,
+	}
+
+	// Warm up
+	var e Engine
+	defer e.Close()
+	dst := image.NewNRGBA64(img.Rect)
+	if err := e.ApplyInto(&opt, dst, dst.Rect, img); err != nil {
+		b.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := e.ApplyInto(&opt, dst, dst.Rect, img); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	// The pools drop the scratch buffers at random under the race detector
+	if n := (after.TotalAlloc - before.TotalAlloc) / uint64(b.N); n > uint64(len(dst.Pix)/16) && !raceEnabled {
+		b.Errorf("ApplyInto allocates %d bytes per call", n)
+	}
+}
+
+func BenchmarkEngineThreads(b *testing.B) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1000, 1000))
+
+	opt := Options{
+		MinIterations:  10,
+		MaxIterations:  10,
+		BlockSize:      16,
+		MinSegmentSize: 0.01,
+		MaxSegmentSize: 1,
+		MinFilters:     4,
+		MaxFilters:     4,
+	}
+
+	var e Engine
+	defer e.Close()
+	for i := 0; i < b.N; i++ {
+		_, err := e.Apply(&opt, img)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func gradient(r image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 5), uint8(x ^ y), 0xff})
+		}
+	}
+	return img
+}
+
+var oddSizes = []image.Rectangle{
+	image.Rect(0, 0, 37, 29),
+	image.Rect(0, 0, 16, 17),
+	image.Rect(0, 0, 5, 3),
+	image.Rect(3, 7, 40, 26),
+}
+
+func TestEdgeBlocks(t *testing.T) {
+	for _, pad := range []bool{false, true} {
+		for _, r := range oddSizes {
+			opt := Options{
+				MinIterations:  1,
+				MaxIterations:  1,
+				BlockSize:      8,
+				MinSegmentSize: 1,
+				MaxSegmentSize: 1,
+				MinFilters:     1,
+				MaxFilters:     1,
+				Filters:        []string{"color"},
+				Ops:            []string{"src"},
+				PadEdges:       pad,
+			}
+
+			res, err := opt.Apply(gradient(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Bounds().Dx() != r.Dx() || res.Bounds().Dy() != r.Dy() {
+				t.Fatalf("pad: %t, %v: unexpected bounds %v", pad, r, res.Bounds())
+			}
+
+			// The whole image must be filled with a single color
+			b := res.Bounds()
+			c := res.At(b.Min.X, b.Min.Y)
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if res.At(x, y) != c {
+						t.Fatalf("pad: %t, %v: pixel (%d,%d) is untouched", pad, r, x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOddSizes(t *testing.T) {
+	for _, mode := range []struct{ pad, wrap bool }{{false, false}, {true, false}, {false, true}, {true, true}} {
+		for _, r := range oddSizes {
+			opt := Options{
+				MinIterations:  20,
+				MaxIterations:  20,
+				BlockSize:      8,
+				MinSegmentSize: 0.5,
+				MaxSegmentSize: 1,
+				MinFilters:     1,
+				MaxFilters:     4,
+				PadEdges:       mode.pad,
+				PixelShiftX:    5,
+				PixelShiftY:    11,
+				WrapEdges:      mode.wrap,
+				Threads:        3,
+			}
+			if r.Dx()*r.Dy() < 64 {
+				opt.MinSegmentSize = 1
+			}
+
+			res, err := opt.Apply(gradient(r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Bounds().Dx() != r.Dx() || res.Bounds().Dy() != r.Dy() {
+				t.Fatalf("%+v, %v: unexpected bounds %v", mode, r, res.Bounds())
+			}
+		}
+	}
+}
+
+func TestPixelShift(t *testing.T) {
+	r := image.Rect(0, 0, 40, 24)
+	src := new(buffer).reuse(r, 8)
+	src.loadImage(gradient(r), r)
+
+	for _, wrapEdges := range []bool{false, true} {
+		dst := new(buffer).reuse(r, 8)
+		p := pass{
+			filter:    filterSource{},
+			op:        opReplace{},
+			dst:       dst,
+			src:       src,
+			bounds:    r,
+			blockSize: 8,
+			blocksX:   5,
+			blocks:    15,
+			segBlocks: 15,
+			offset:    image.Pt(5, -3),
+			first:     true,
+			wrap:      wrapEdges,
+		}
+		p.run(0, p.blocks)
+
+		at := func(b *buffer, x, y int) NRGBA {
+			var c [1]NRGBA
+			b.load(c[:], x, y)
+			return c[0]
+		}
+		for _, tt := range []struct {
+			x, y   int // Destination
+			sx, sy int // Expected source
+		}{
+			{10, 10, 15, 7},
+			// The last block column reads past the right edge, the first block row reads above the top one
+			{36, 2, 1, 23},
+			{39, 23, 4, 20},
+		} {
+			sx, sy := tt.sx, tt.sy
+			if !wrapEdges {
+				// The source window is moved back inside the image as a whole
+				sx, sy = tt.x+5, tt.y-3
+				if tt.x >= 32 {
+					sx = tt.x
+				}
+				if tt.y < 8 {
+					sy = tt.y
+				}
+			}
+			if c, expected := at(dst, tt.x, tt.y), at(src, sx, sy); c != expected {
+				t.Errorf("wrap: %t: pixel %d,%d is %v, expected %v from %d,%d", wrapEdges, tt.x, tt.y, c, expected, sx, sy)
+			}
+		}
+	}
+}
+
+// TestDepth checks that the 8-bit path matches the 16-bit one within rounding. A single iteration is used
+// as quantizing filters amplify the rounding differences on subsequent iterations. For the same reason only
+// the filters and operations continuous in their input take part: thresholds, bit operations, hue and
+// line picking turn a rounding difference of the intermediate image into an arbitrary one. The intermediate
+// 8-bit images are off by half a unit at most but a YCbCr permutation may scale it by up to 1.4 so the
+// chained filters end up within two units
+func TestDepth(t *testing.T) {
+	r := image.Rect(0, 0, 128, 96)
+	src8 := gradient(r)
+	src16 := image.NewNRGBA64(r)
+	draw.Draw(src16, r, src8, image.Point{}, draw.Src)
+
+	opt := Options{
+		MinIterations:  1,
+		MaxIterations:  1,
+		BlockSize:      16,
+		MinSegmentSize: 0.5,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     3,
+		Filters: []string{"color", "gray", "src", "rgba", "seta", "ycc", "prgb", "prgba", "pycc", "copy", "ctoa",
+			"mix", "inv", "invrgba", "inva", "invycc", "gs", "aberr", "wave"},
+		Ops: []string{"cmp", "src", "add", "mulrgb", "mulycc", "screen", "overlay", "softlight", "hardlight",
+			"diff", "excl", "sub", "darken", "lighten"},
+	}
+	for seed := int64(1); seed <= 50; seed++ {
+		rand.Seed(seed)
+		res8, err := opt.Apply(src8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rand.Seed(seed)
+		res16, err := opt.Apply(src16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p8, p16 := res8.(*image.NRGBA).Pix, res16.(*image.NRGBA).Pix
+		for i := range p8 {
+			if d := int(p8[i]) - int(p16[i]); d < -2 || d > 2 {
+				t.Fatalf("seed %d: byte %d differs: %d vs %d", seed, i, p8[i], p16[i])
+			}
+		}
+	}
+}
+
+func TestOutput16(t *testing.T) {
+	r := image.Rect(0, 0, 40, 24)
+	src := image.NewNRGBA64(r)
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 13)
+	}
+	opt := Options{
+		BlockSize:      8,
+		MinSegmentSize: 0.5,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     1,
+		Output16:       true,
+	}
+	res, err := opt.Apply(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, ok := res.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("unexpected result type %T", res)
+	}
+	// No iterations, the precision must be preserved
+	if !bytes.Equal(img.Pix, src.Pix) {
+		t.Error("16-bit image is altered")
+	}
+}
+
+func TestChainOpacity(t *testing.T) {
+	r := image.Rect(0, 0, 40, 24)
+	src := image.NewNRGBA64(r)
+	draw.Draw(src, r, image.NewUniform(color.NRGBA64{0x2000, 0x2000, 0x2000, 0xffff}), image.Point{}, draw.Src)
+	opt := Options{
+		MinIterations:  1,
+		MaxIterations:  1,
+		BlockSize:      8,
+		MinSegmentSize: 1,
+		MaxSegmentSize: 1,
+		MinFilters:     2,
+		MaxFilters:     2,
+		Filters:        []string{"inv"},
+		Ops:            []string{"src"},
+		Opacity:        true,
+		MinOpacity:     0.5,
+		MaxOpacity:     0.5,
+		Output16:       true,
+	}
+	res, err := opt.Apply(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both inversions are mixed with the image halfway: 0x2000 -> 0x7fff -> 0x8000 -> 0x5000.
+	// With the first one opaque the second would invert it back to 0x2000
+	expected := color.NRGBA64{0x5000, 0x5000, 0x5000, 0xffff}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if c := res.(*image.NRGBA64).NRGBA64At(x, y); c != expected {
+				t.Fatalf("pixel %d,%d is %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+
+	// Zero opacity leaves the image intact
+	opt.MinOpacity, opt.MaxOpacity = 0, 0
+	if res, err = opt.Apply(src); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.(*image.NRGBA64).Pix, src.Pix) {
+		t.Error("image is altered at zero opacity")
+	}
+}
+
+func TestLinearLight(t *testing.T) {
+	r := image.Rect(0, 0, 40, 24)
+	src := gradient(r)
+	opt := Options{
+		BlockSize:      8,
+		MinSegmentSize: 0.5,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     1,
+		LinearLight:    true,
+	}
+	res, err := opt.Apply(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No iterations, the conversion must be lossless for 8 bit images
+	if !bytes.Equal(res.(*image.NRGBA).Pix, src.Pix) {
+		t.Error("image is altered")
+	}
+
+	// Adding the image to itself doubles the light. sRGB 0x6379 is 0.125 in linear light so the sum
+	// is 0.25 or sRGB 0x897f while adding the sRGB values would give 0xc6f2
+	gray := image.NewNRGBA64(r)
+	draw.Draw(gray, r, image.NewUniform(color.NRGBA64{0x6379, 0x6379, 0x6379, 0xffff}), image.Point{}, draw.Src)
+	opt = Options{
+		MinIterations:  1,
+		MaxIterations:  1,
+		BlockSize:      8,
+		MinSegmentSize: 1,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     1,
+		Filters:        []string{"src"},
+		Ops:            []string{"add"},
+		LinearLight:    true,
+		Output16:       true,
+	}
+	if res, err = opt.Apply(gray); err != nil {
+		t.Fatal(err)
+	}
+	if c := res.(*image.NRGBA64).NRGBA64At(0, 0); c.R < 0x897d || c.R > 0x8981 || c.G != c.R || c.B != c.R {
+		t.Errorf("add: %#v, expected 0x897f", c)
+	}
+}
+
+func TestLinearSRGBOps(t *testing.T) {
+	// The operations and filters defined on sRGB values must give the same results in linear light
+	toLinear, toSRGB := linearTables()
+	lin := func(c NRGBA) NRGBA {
+		s := []NRGBA{c}
+		mapSpan(s, toLinear)
+		return s[0]
+	}
+	srgb := func(c NRGBA) NRGBA {
+		s := []NRGBA{c}
+		mapSpan(s, toSRGB)
+		return s[0]
+	}
+	dst, src := NRGBA{0x8080, 0x4040, 0xc0c0, 0xffff}, NRGBA{0x2020, 0xe0e0, 0x6060, 0xffff}
+
+	for _, op := range []Operation{opXorYCC{}, opBitRGB{bitAnd}, GetOpID("hue")} {
+		expected := op.Apply(dst, src)
+		if res := srgb(linearLight(op).Apply(lin(dst), lin(src))); !near(res, expected) {
+			t.Errorf("%v: %#x, expected %#x", op, res, expected)
+		}
+	}
+	for _, f := range []Filter{filterInvYCCComp{c: 0}, filterQuantRGBA{4, 4, 4, 0}, filterColor(color.NRGBA{0x80, 0x80, 0x80, 0xff}),
+		filterSetRGBAComp{1, 0x80}} {
+		expected := []NRGBA{dst}
+		f.(spanFilter).filterSpan(expected, 0, 0)
+		s := []NRGBA{lin(dst)}
+		linearFilter(f).(spanFilter).filterSpan(s, 0, 0)
+		if res := srgb(s[0]); !near(res, expected[0]) {
+			t.Errorf("%v: %#x, expected %#x", f, res, expected[0])
+		}
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	r := image.Rect(0, 0, 100, 75)
+	src8 := gradient(r)
+	src16 := image.NewNRGBA64(r)
+	draw.Draw(src16, r, src8, image.Point{}, draw.Src)
+
+	for _, src := range []image.Image{src8, src16} {
+		opt := Options{
+			MinIterations:  10,
+			MaxIterations:  10,
+			BlockSize:      8,
+			MinSegmentSize: 0.1,
+			MaxSegmentSize: 1,
+			MinFilters:     1,
+			MaxFilters:     3,
+			Threads:        3,
+			PadEdges:       true,
+			PixelShiftY:    5,
+			FeatherBlock:   3,
+			Content:        ContentEdges,
+		}
+		rand.Seed(1)
+		ref, err := opt.Apply(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A few rows per stripe
+		opt.MemoryBudget = 4096
+		rand.Seed(1)
+		res, err := opt.Apply(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res.(*image.NRGBA).Pix, ref.(*image.NRGBA).Pix) {
+			t.Errorf("%T: result differs from the in-memory one", src)
+		}
+
+		// Read from the stripe stores without copying
+		var e Engine
+		out := image.NewNRGBA(r)
+		rand.Seed(1)
+		err = e.ApplyFunc(&opt, src, func(res image.Image) error {
+			if _, ok := res.(*bufferImage); !ok {
+				t.Errorf("%T: result is %T", src, res)
+			}
+			draw.Draw(out, r, res, image.Point{}, draw.Src)
+			return nil
+		})
+		e.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Pix, ref.(*image.NRGBA).Pix) {
+			t.Errorf("%T: streamed result differs from the in-memory one", src)
+		}
+	}
+}
+
+func TestSubImageInput(t *testing.T) {
+	canvas := image.NewNRGBA64(image.Rect(0, 0, 100, 80))
+	draw.Draw(canvas, canvas.Bounds(), gradient(canvas.Bounds()), image.Point{}, draw.Src)
+	r := image.Rect(17, 9, 83, 61)
+	sub := canvas.SubImage(r)
+	standalone := image.NewNRGBA64(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(standalone, standalone.Bounds(), canvas, r.Min, draw.Src)
+
+	opt := Options{
+		MinIterations:  10,
+		MaxIterations:  10,
+		BlockSize:      8,
+		MinSegmentSize: 0.1,
+		MaxSegmentSize: 0.5,
+		MinFilters:     1,
+		MaxFilters:     3,
+		Output16:       true,
+	}
+	rand.Seed(1)
+	ref, err := opt.Apply(standalone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rand.Seed(1)
+	res, err := opt.Apply(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res.(*image.NRGBA64).Pix, ref.(*image.NRGBA64).Pix) {
+		t.Error("sub-image result differs from the standalone one")
+	}
+}
+
+func TestApplyInto(t *testing.T) {
+	canvas := gradient(image.Rect(0, 0, 100, 80))
+	r := image.Rect(17, 9, 83, 61)
+	standalone := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(standalone, standalone.Bounds(), canvas, r.Min, draw.Src)
+
+	opt := Options{
+		MinIterations:  10,
+		MaxIterations:  10,
+		BlockSize:      8,
+		MinSegmentSize: 0.1,
+		MaxSegmentSize: 0.5,
+		MinFilters:     1,
+		MaxFilters:     3,
+		PadEdges:       true,
+	}
+	rand.Seed(1)
+	ref, err := opt.Apply(standalone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// In place
+	img := gradient(canvas.Rect)
+	rand.Seed(1)
+	if err := opt.ApplyInto(img, r, img); err != nil {
+		t.Fatal(err)
+	}
+	for y := canvas.Rect.Min.Y; y < canvas.Rect.Max.Y; y++ {
+		for x := canvas.Rect.Min.X; x < canvas.Rect.Max.X; x++ {
+			expected := canvas.NRGBAAt(x, y)
+			if image.Pt(x, y).In(r) {
+				expected = ref.(*image.NRGBA).NRGBAAt(x-r.Min.X, y-r.Min.Y)
+			}
+			if c := img.NRGBAAt(x, y); c != expected {
+				t.Fatalf("pixel %d,%d is %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+}
+
+func TestEngineReuse(t *testing.T) {
+	img := gradient(image.Rect(0, 0, 256, 256))
+	opt := Options{
+		MinIterations:  10,
+		MaxIterations:  10,
+		BlockSize:      16,
+		MinSegmentSize: 0.1,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     3,
+		Threads:        1,
+	}
+
+	// The results of the consecutive calls must not share the memory
+	var e Engine
+	defer e.Close()
+	a, err := e.Apply(&opt, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := append([]uint8(nil), a.(*image.NRGBA).Pix...)
+	b, err := e.Apply(&opt, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &a.(*image.NRGBA).Pix[0] == &b.(*image.NRGBA).Pix[0] || !bytes.Equal(a.(*image.NRGBA).Pix, saved) {
+		t.Fatal("the result was overwritten by the next call")
+	}
+
+	// Once warmed up ApplyInto allocates only the per iteration filters and the scratch buffers
+	// the pools drop on GC, nothing of the image size. The race detector makes the pools drop them at random
+	if raceEnabled {
+		return
+	}
+	dst := image.NewNRGBA(img.Rect)
+	if err := e.ApplyInto(&opt, dst, dst.Rect, img); err != nil {
+		t.Fatal(err)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	const runs = 10
+	for i := 0; i < runs; i++ {
+		if err := e.ApplyInto(&opt, dst, dst.Rect, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if n := (after.TotalAlloc - before.TotalAlloc) / runs; n > uint64(len(dst.Pix)/16) {
+		t.Errorf("ApplyInto allocates %d bytes per call", n)
+	}
+}
+
+func TestEngineClose(t *testing.T) {
+	img := gradient(image.Rect(0, 0, 64, 64))
+	opt := Options{
+		MinIterations:  1,
+		MaxIterations:  1,
+		BlockSize:      8,
+		MinSegmentSize: 1,
+		MaxSegmentSize: 1,
+		MinFilters:     1,
+		MaxFilters:     1,
+		Threads:        4,
+	}
+
+	n := runtime.NumGoroutine()
+	var e Engine
+	if _, err := e.Apply(&opt, img); err != nil {
+		t.Fatal(err)
+	}
+	if g := runtime.NumGoroutine(); g != n+3 {
+		t.Fatalf("%d goroutines running, expected %d", g, n+3)
+	}
+	e.Close()
+	for i := 0; i < 100 && runtime.NumGoroutine() != n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if g := runtime.NumGoroutine(); g != n {
+		t.Fatalf("%d goroutines left running after Close, expected %d", g, n)
+	}
+}
+
+// TestGolden guards the bit exact reproducibility of seeded runs across architectures and builds
+func TestGolden(t *testing.T) {
+	r := image.Rect(0, 0, 97, 61)
+	src8 := gradient(r)
+	src16 := image.NewNRGBA64(r)
+	for i := range src16.Pix {
+		src16.Pix[i] = uint8(i*7 + i>>3)
+	}
+
+	base := Options{
+		MinIterations:  20,
+		MaxIterations:  20,
+		BlockSize:      8,
+		MinSegmentSize: 0.05,
+		MaxSegmentSize: 0.5,
+		MinFilters:     1,
+		MaxFilters:     3,
+		Threads:        2,
+		// Explicit lists so new filters and ops don't change the random sequence
+		Filters: []string{"color", "copy", "ctoa", "gray", "gs", "inv", "inva", "invrgba", "invycc", "mix", "prgb",
+			"prgba", "pycc", "qrgba", "quant", "qy", "qycca", "rasp", "rgba", "seta", "src", "ycc"},
+		Ops: []string{"add", "addrgbm", "addyccm", "cmp", "mulrgb", "mulycc", "src", "xorrgb", "xorycc"},
+	}
+	tests := []struct {
+		name string
+		src  image.Image
+		opt  func(o *Options)
+		seed int64
+		hash string
+	}{
+		{"default", src8, func(o *Options) {}, 1, "86052999156372f1"},
+		{"default", src8, func(o *Options) {}, 2, "b98ac1eea58d1d8f"},
+		{"16bit", src16, func(o *Options) { o.Output16 = true }, 3, "5c63954d643c7b29"},
+		{"legacy", src8, func(o *Options) { o.LegacyYCC = true }, 4, "4a43403e4546d508"},
+		{"mix", src16, func(o *Options) { o.Filters = []string{"mix"}; o.Output16 = true }, 5, "d28d8a6abd51c52d"},
+		{"shift", src8, func(o *Options) { o.PixelShiftX, o.PixelShiftY, o.WrapEdges, o.PadEdges = 5, 3, true, true }, 6, "3bcaced9a49155a3"},
+		{"feather", src8, func(o *Options) { o.FeatherBlock, o.FeatherRun, o.FeatherCurve = 3, 20, FeatherSmooth }, 7, "0278cefbf8a3746e"},
+		{"gaussian", src8, func(o *Options) { o.Placement, o.PlacementCenter, o.PlacementSpread = PlacementGaussian, 0.3, 0.2 }, 8, "3a6f50b6ff381a0c"},
+		{"cluster", src8, func(o *Options) { o.Placement, o.PlacementClusters, o.PlacementSpread = PlacementCluster, 3, 0.05 }, 9, "38d66954890c7df2"},
+		{"follow", src8, func(o *Options) { o.Placement, o.PlacementSpread = PlacementFollow, 0.1 }, 10, "c055c22c16889a0a"},
+		{"entropy", src16, func(o *Options) { o.Content, o.ContentStrength = ContentEntropy, 1.5 }, 11, "83c292523245240c"},
+		{"variance", src8, func(o *Options) { o.Content, o.ContentFlat, o.ContentStrength = ContentVariance, true, 0.7 }, 12, "46227f94a04a4b2b"},
+		// Float code must give the same bits on every architecture
+		{"wave", src8, func(o *Options) { o.Filters, o.MinWaveAmp, o.MaxWaveAmp = []string{"wave", "inv"}, 1, 12 }, 13, "418f36c6feace33e"},
+		{"wave16", src16, func(o *Options) { o.Filters, o.WrapEdges, o.Output16 = []string{"wave"}, true, true }, 14, "40e6f1015347025e"},
+		{"lab", src16, func(o *Options) {
+			o.Filters, o.Ops, o.Output16 = []string{"qlab", "plab", "mixlab"}, []string{"addlabm", "src"}, true
+		}, 15, "e43d88af93d0ac11"},
+		{"linear", src8, func(o *Options) { o.LinearLight = true }, 16, "9dcd7ab3513b01e8"},
+		{"linearlab", src16, func(o *Options) {
+			o.Filters, o.Ops, o.LinearLight, o.Output16 = []string{"qlab", "mixlab", "inv", "rasp"}, []string{"addlabm", "add", "hue"}, true, true
+		}, 17, "88ccb754a4ef4deb"},
+	}
+
+	for _, tt := range tests {
+		opt := base
+		tt.opt(&opt)
+		rand.Seed(tt.seed)
+		res, err := opt.Apply(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pix []uint8
+		switch img := res.(type) {
+		case *image.NRGBA:
+			pix = img.Pix
+		case *image.NRGBA64:
+			pix = img.Pix
+		}
+		sum := sha256.Sum256(pix)
+		if hash := hex.EncodeToString(sum[:8]); hash != tt.hash {
+			t.Errorf("%s/%d: hash %s, expected %s", tt.name, tt.seed, hash, tt.hash)
+		}
+	}
+}
+
+func TestChainEdges(t *testing.T) {
+	// The filters later in a chain sample around their blocks. They must see the image there
+	// and not the cleared or stale intermediate images
+	r := image.Rect(0, 0, 64, 64)
+	img := image.NewNRGBA(r)
+	draw.Draw(img, r, image.NewUniform(color.NRGBA{0x80, 0x80, 0x80, 0xff}), image.Point{}, draw.Src)
+	for _, name := range []string{"aberr", "wave"} {
+		for seed := int64(1); seed <= 30; seed++ {
+			opt := Options{
+				MinIterations:  5,
+				MaxIterations:  5,
+				BlockSize:      8,
+				MinSegmentSize: 0.1,
+				MaxSegmentSize: 0.5,
+				MinFilters:     2,
+				MaxFilters:     2,
+				Threads:        1,
+				Filters:        []string{name},
+				Ops:            []string{"src"},
+			}
+			rand.Seed(seed)
+			res, err := opt.Apply(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res.(*image.NRGBA).Pix, img.Pix) {
+				t.Errorf("%s/%d: the uniform image changed", name, seed)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"image"
+)
+
+const (
+	FeatherLinear = iota
+	FeatherSmooth
+	FeatherQuad
+	FeatherNumCurves
+)
+
+var featherCurveNames = map[string]int{
+	"linear": FeatherLinear,
+	"smooth": FeatherSmooth,
+	"quad":   FeatherQuad,
+}
+
+func GetFeatherCurveID(name string) int {
+	if id, ok := featherCurveNames[name]; ok {
+		return id
+	}
+	return -1
+}
+
+// newRamp returns the weights for the distances 0..n-1 from the edge, scaled to 0xffff
+func newRamp(n int, curve int) []uint32 {
+	ramp := make([]uint32, n)
+	for d := range ramp {
+		t := uint64(d+1) * 0xffff / uint64(n+1)
+		switch curve {
+		case FeatherSmooth:
+			t = t * t * (3*0xffff - 2*t) / (0xffff * 0xffff)
+		case FeatherQuad:
+			t = t * t / 0xffff
+		}
+		ramp[d] = uint32(t)
+	}
+	return ramp
+}
+
+type featherer struct {
+	block []uint32
+	run   []uint32
+}
+
+func newFeatherer(block, run, curve int) *featherer {
+	if block <= 0 && run <= 0 {
+		return nil
+	}
+	return &featherer{
+		block: newRamp(block, curve),
+		run:   newRamp(run, curve),
+	}
+}
+
+func rampWeight(ramp []uint32, d int) uint32 {
+	if d < len(ramp) {
+		return ramp[d]
+	}
+	return 0xffff
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// apply blends the filtered block in dst with the original image using the distance to the block edges
+// and to the ends of the segment run. pos is the offset of the block along the run of length ln
+func (f *featherer) apply(dst *buffer, dr image.Rectangle, orig *buffer, pos, ln int) {
+	buf := spanPool.Get().(*spanBuffers)
+	defer spanPool.Put(buf)
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		wy := rampWeight(f.block, minInt(y-dr.Min.Y, dr.Max.Y-1-y))
+		for x0 := dr.Min.X; x0 < dr.Max.X; x0 += spanLen {
+			d, o := buf.d[:minInt(dr.Max.X-x0, spanLen)], buf.s[:minInt(dr.Max.X-x0, spanLen)]
+			dst.load(d, x0, y)
+			orig.load(o, x0, y)
+			for i := range d {
+				x := x0 + i
+				wb := rampWeight(f.block, minInt(x-dr.Min.X, dr.Max.X-1-x))
+				if wy < wb {
+					wb = wy
+				}
+				p := pos + x - dr.Min.X
+				k := (wb * rampWeight(f.run, minInt(p, ln-1-p))) / 0xffff
+
+				if k != 0xffff {
+					for c := range d[i] {
+						d[i][c] = (o[i][c]*(0xffff-k) + d[i][c]*k) / 0xffff
+					}
+				}
+			}
+			dst.store(d, x0, y)
+		}
+	}
+}
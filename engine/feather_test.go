@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRamp(t *testing.T) {
+	for _, tt := range []struct {
+		curve int
+		ramp  []uint32
+	}{
+		{FeatherLinear, []uint32{16383, 32767, 49151}},
+		{FeatherSmooth, []uint32{10239, 32766, 55294}},
+		{FeatherQuad, []uint32{4095, 16383, 36863}},
+	} {
+		ramp := newRamp(3, tt.curve)
+		for d, w := range tt.ramp {
+			if ramp[d] != w {
+				t.Errorf("curve %d: weight %d is %d, expected %d", tt.curve, d, ramp[d], w)
+			}
+		}
+	}
+}
+
+func TestFeatherRun(t *testing.T) {
+	// 3x2 blocks, the last column is 4 pixels wide
+	r := image.Rect(0, 0, 20, 16)
+	orig := new(buffer).reuse(r, 8)
+	dst := new(buffer).reuse(r, 8)
+	p := pass{
+		filter:    filterColor(color.NRGBA{0xff, 0xff, 0xff, 0xff}),
+		op:        opReplace{},
+		dst:       dst,
+		src:       orig,
+		orig:      orig,
+		bounds:    r,
+		blockSize: 8,
+		blocksX:   3,
+		blocks:    6,
+		segStart:  1,
+		segBlocks: 4,
+		feather:   newFeatherer(0, 14, FeatherLinear),
+	}
+	p.run(p.segStart, p.segBlocks)
+
+	// The run is 8+4+8+8 pixels long and the weights grow by 0xffff/15 per pixel from either end
+	for _, tt := range []struct {
+		x, y int
+		v    uint32
+	}{
+		{0, 0, 0},
+		{8, 0, 4369},
+		{19, 3, 52428},
+		{0, 8, 56797},
+		{10, 9, 26214},
+		{15, 15, 4369},
+		{16, 8, 0},
+	} {
+		var c [1]NRGBA
+		dst.load(c[:], tt.x, tt.y)
+		if c[0][0] != tt.v {
+			t.Errorf("pixel %d,%d is %d, expected %d", tt.x, tt.y, c[0][0], tt.v)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"image/color"
 	"math/rand"
 	"sort"
+	"sync"
 )
 
 const (
@@ -31,6 +32,22 @@ const (
 	FilterInvYCCComp
 	FilterGrayscale
 	FilterBitRasp
+	FilterRotHue
+	FilterSetHSVComp
+	FilterSetHSLComp
+	FilterPermHSV
+	FilterPermHSL
+	FilterQuantHSVA
+	FilterQuantHSLA
+	FilterInvHSVComp
+	FilterInvHSLComp
+	FilterQuantLab
+	FilterPermLab
+	FilterMixLab
+	FilterSort
+	FilterAberr
+	FilterWave
+	FilterSmear
 	FilterNumFilters
 )
 
@@ -40,45 +57,152 @@ type Filter interface {
 }
 
 type FilterOptions struct {
-	Reference *image.NRGBA64
-	BlockSize int
+	BlockSize   int
+	LegacyYCC   bool // Use the 8 bit YCbCr conversion
+	LinearLight bool // The pixels are in linear light instead of sRGB
+	WrapEdges   bool // Samples outside of the image wrap around instead of repeating the edge pixels
+
+	// Wave amplitude in pixels and frequency in periods per row. Zero maximums pick them by BlockSize,
+	// the minimums must be zero then
+	MinWaveAmp, MaxWaveAmp   int
+	MinWaveFreq, MaxWaveFreq float64
 }
 
 type filterConstructor func(opt *FilterOptions) Filter
 
+// spanFilter transforms a span of source pixels in place. x and y are the destination coordinates of the first pixel
+type spanFilter interface {
+	filterSpan(s []NRGBA, x, y int)
+}
+
+// blockFilter needs random access to the source so it runs the operation and stores the block itself
+type blockFilter interface {
+	filterBlock(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation)
+}
+
+// segmentFilter may process the segment band by band instead of block by block. The bands are the block rows
+// of the segment or its block columns if vertical is set. filterBand gets the band dr, the source aligned
+// with the destination and the bounding rectangle of the segment
+type segmentFilter interface {
+	segment() (on, vertical bool)
+	filterBand(dst *buffer, dr image.Rectangle, src *buffer, seg image.Rectangle, op Operation)
+}
+
+// bandReserve returns the area of the longest band as wide as dr so the scratch buffers
+// of the bands are allocated once
+func bandReserve(dr, bounds image.Rectangle, vertical bool) int {
+	if vertical {
+		return dr.Dx() * bounds.Dy()
+	}
+	return bounds.Dx() * dr.Dy()
+}
+
+func segmentMode(f Filter) (on, vertical bool) {
+	if sf, ok := f.(segmentFilter); ok {
+		return sf.segment()
+	}
+	return false, false
+}
+
+const spanLen = 64
+
+type spanBuffers struct {
+	s, d [spanLen]NRGBA
+}
+
+var spanPool = sync.Pool{
+	New: func() interface{} { return new(spanBuffers) },
+}
+
+// applyFilter avoids boxing the filter again if it provides a span kernel
+func applyFilter(f Filter, dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	if sf, ok := f.(spanFilter); ok {
+		applySpanFilter(sf, dst, dr, src, sp, op)
+	} else if bf, ok := f.(blockFilter); ok {
+		bf.filterBlock(dst, dr, src, sp, op)
+	} else {
+		// Generic filters work on 16 bit images only
+		f.Apply(dst.nrgba64(), dr, src.nrgba64(), sp, op)
+	}
+}
+
+type areaBuffers struct {
+	pix, d []NRGBA
+}
+
+var areaPool = sync.Pool{
+	New: func() interface{} { return new(areaBuffers) },
+}
+
+// applyAreaFilter loads the source area of the block dr into a scratch buffer reserving the capacity
+// for reserve pixels, lets fn change the w*h pixels in place and stores them through the operation
+func applyAreaFilter(fn func(pix []NRGBA, w, h int), dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation, reserve int) {
+	w, h := dr.Dx(), dr.Dy()
+	if w <= 0 || h <= 0 {
+		return
+	}
+	buf := areaPool.Get().(*areaBuffers)
+	defer areaPool.Put(buf)
+	buf.pix, buf.d = growPixels(buf.pix, w*h, reserve), growPixels(buf.d, w, src.Rect.Dx())
+	pix := buf.pix
+	for y := 0; y < h; y++ {
+		src.load(pix[y*w:(y+1)*w], sp.X, sp.Y+y)
+	}
+
+	fn(pix, w, h)
+
+	_, replace := op.(opReplace)
+	for y := 0; y < h; y++ {
+		storeOp(dst, dr.Min.X, dr.Min.Y+y, pix[y*w:(y+1)*w], buf.d, op, replace)
+	}
+}
+
+// applySpanFilter runs the filter and the operation over the block span by span
+func applySpanFilter(f spanFilter, dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	buf := spanPool.Get().(*spanBuffers)
+	defer spanPool.Put(buf)
+	_, replace := op.(opReplace)
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		sy := sp.Y + y - dr.Min.Y
+		for x := dr.Min.X; x < dr.Max.X; x += spanLen {
+			n := dr.Max.X - x
+			if n > spanLen {
+				n = spanLen
+			}
+			s := buf.s[:n]
+
+			src.load(s, sp.X+x-dr.Min.X, sy)
+			f.filterSpan(s, x, y)
+			storeOp(dst, x, y, s, buf.d[:n], op, replace)
+		}
+	}
+}
+
+// storeOp runs the operation over the destination pixels at (x, y) and the filtered span s and stores the result.
+// d is a scratch span of the same length
+func storeOp(dst *buffer, x, y int, s, d []NRGBA, op Operation, replace bool) {
+	if !replace {
+		dst.load(d, x, y)
+		ApplySpan(op, d, s)
+		s = d
+	}
+	dst.store(s, x, y)
+}
+
 type filterColor color.NRGBA
 
 func (f filterColor) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterColor) filterSpan(s []NRGBA, x, y int) {
 	sr := (uint32(f.R) << 8) | uint32(f.R)
 	sg := (uint32(f.G) << 8) | uint32(f.G)
 	sb := (uint32(f.B) << 8) | uint32(f.B)
 	sa := (uint32(f.A) << 8) | uint32(f.A)
-	s := NRGBA{sr, sg, sb, sa}
-
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, s)
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
+	for i := range s {
+		s[i] = NRGBA{sr, sg, sb, sa}
 	}
 }
 
@@ -105,43 +229,13 @@ type filterSetRGBAComp struct {
 }
 
 func (f filterSetRGBAComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	val := (uint32(f.v) << 8) | uint32(f.v)
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			v := NRGBA{sr, sg, sb, sa}
-			v[f.c] = val
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, v)
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+func (f filterSetRGBAComp) filterSpan(s []NRGBA, x, y int) {
+	val := (uint32(f.v) << 8) | uint32(f.v)
+	for i := range s {
+		s[i][f.c] = val
 	}
 }
 
@@ -160,41 +254,11 @@ func newFilterSetA(opt *FilterOptions) Filter {
 type filterSource struct{}
 
 func (f filterSource) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
-	}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
 }
 
+func (f filterSource) filterSpan(s []NRGBA, x, y int) {}
+
 func (f filterSource) String() string {
 	return "src"
 }
@@ -204,51 +268,27 @@ func newFilterSource(opt *FilterOptions) Filter {
 }
 
 type filterSetYCCComp struct {
-	c, v uint8
+	c, v   uint8
+	legacy bool
 }
 
 func (f filterSetYCCComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			sY, sCb, sCr := color.RGBToYCbCr(uint8(sr>>8), uint8(sg>>8), uint8(sb>>8))
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterSetYCCComp) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		if f.legacy {
+			sY, sCb, sCr := rgbToYCC8(c[0], c[1], c[2])
 			v := [3]uint8{sY, sCb, sCr}
 			v[f.c] = f.v
-
-			r8, g8, b8 := color.YCbCrToRGB(v[0], v[1], v[2])
-			sr = (uint32(r8) << 8) | uint32(r8)
-			sg = (uint32(g8) << 8) | uint32(g8)
-			sb = (uint32(b8) << 8) | uint32(b8)
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+			s[i][0], s[i][1], s[i][2] = ycc8ToRGB(v[0], v[1], v[2])
+			continue
 		}
-		di += dst.Stride
-		si += src.Stride
+		sY, sCb, sCr := rgbToYCC(c[0], c[1], c[2])
+		v := [3]uint32{sY, sCb, sCr}
+		v[f.c] = uint32(f.v) * 0x101
+		s[i][0], s[i][1], s[i][2] = yccToRGB(v[0], v[1], v[2])
 	}
 }
 
@@ -257,45 +297,18 @@ func (f filterSetYCCComp) String() string {
 }
 
 func newFilterSetYCCComp(opt *FilterOptions) Filter {
-	return filterSetYCCComp{uint8(rand.Intn(3)), uint8(rand.Intn(256))}
+	return filterSetYCCComp{uint8(rand.Intn(3)), uint8(rand.Intn(256)), opt.LegacyYCC}
 }
 
 type filterPermRGBA [4]int
 
 func (f filterPermRGBA) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			v := NRGBA{sr, sg, sb, sa}
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{v[f[0]], v[f[1]], v[f[2]], v[f[3]]})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterPermRGBA) filterSpan(s []NRGBA, x, y int) {
+	for i, v := range s {
+		s[i] = NRGBA{v[f[0]], v[f[1]], v[f[2]], v[f[3]]}
 	}
 }
 
@@ -319,40 +332,12 @@ type filterCopyComp struct {
 }
 
 func (f filterCopyComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			v := NRGBA{sr, sg, sb, sa}
-			v[f.d] = v[f.s]
-			c := op.Apply(NRGBA{dr, dg, db, da}, v)
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterCopyComp) filterSpan(s []NRGBA, x, y int) {
+	for i := range s {
+		s[i][f.d] = s[i][f.s]
 	}
 }
 
@@ -370,190 +355,111 @@ func newFilterCToA(opt *FilterOptions) Filter {
 	return filterCopyComp{3, uint8(p)}
 }
 
-type filterPermYCC [3]int
+type filterPermYCC struct {
+	p      [3]int
+	legacy bool
+}
 
 func (f filterPermYCC) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			sY, sCb, sCr := color.RGBToYCbCr(uint8(sr>>8), uint8(sg>>8), uint8(sb>>8))
-			v := [3]uint8{sY, sCb, sCr}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-			r8, g8, b8 := color.YCbCrToRGB(v[f[0]], v[f[1]], v[f[2]])
-			sr = (uint32(r8) << 8) | uint32(r8)
-			sg = (uint32(g8) << 8) | uint32(g8)
-			sb = (uint32(b8) << 8) | uint32(b8)
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+func (f filterPermYCC) filterSpan(s []NRGBA, x, y int) {
+	p := f.p
+	for i, c := range s {
+		if f.legacy {
+			sY, sCb, sCr := rgbToYCC8(c[0], c[1], c[2])
+			v := [3]uint8{sY, sCb, sCr}
+			s[i][0], s[i][1], s[i][2] = ycc8ToRGB(v[p[0]], v[p[1]], v[p[2]])
+			continue
 		}
-		di += dst.Stride
-		si += src.Stride
+		sY, sCb, sCr := rgbToYCC(c[0], c[1], c[2])
+		v := [3]uint32{sY, sCb, sCr}
+		s[i][0], s[i][1], s[i][2] = yccToRGB(v[p[0]], v[p[1]], v[p[2]])
 	}
 }
 
 func (f filterPermYCC) String() string {
-	return fmt.Sprintf("pycc:[%d,%d,%d]", f[0], f[1], f[2])
+	return fmt.Sprintf("pycc:[%d,%d,%d]", f.p[0], f.p[1], f.p[2])
 }
 
 func newFilterPermYCC(opt *FilterOptions) Filter {
 	p := rand.Perm(3)
-	return filterPermYCC{p[0], p[1], p[2]}
+	return filterPermYCC{[3]int{p[0], p[1], p[2]}, opt.LegacyYCC}
 }
 
-type filterMix [3][3]float64
+// filterMix multiplies the color by a matrix with mixShift fractional bits
+type filterMix [3][3]int64
+
+const mixShift = 24
 
 func (f filterMix) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			rr := int32(f[0][0]*float64(sr) + f[0][1]*float64(sg) + f[0][2]*float64(sb))
-			gg := int32(f[1][0]*float64(sr) + f[1][1]*float64(sg) + f[1][2]*float64(sb))
-			bb := int32(f[2][0]*float64(sr) + f[2][1]*float64(sg) + f[2][2]*float64(sb))
-
-			if rr < 0 {
-				rr = 0
-			} else if rr > 0xffff {
-				rr = 0xffff
-			}
-			if gg < 0 {
-				gg = 0
-			} else if gg > 0xffff {
-				gg = 0xffff
-			}
-			if bb < 0 {
-				bb = 0
-			} else if bb > 0xffff {
-				bb = 0xffff
-			}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{uint32(rr), uint32(gg), uint32(bb), sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+func (f filterMix) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		rr, gg, bb := f.mul(int64(c[0]), int64(c[1]), int64(c[2]))
+		s[i][0] = clamp16(rr)
+		s[i][1] = clamp16(gg)
+		s[i][2] = clamp16(bb)
 	}
 }
 
+func (f *filterMix) mul(r, g, b int64) (int64, int64, int64) {
+	return (f[0][0]*r + f[0][1]*g + f[0][2]*b) / (1 << mixShift),
+		(f[1][0]*r + f[1][1]*g + f[1][2]*b) / (1 << mixShift),
+		(f[2][0]*r + f[2][1]*g + f[2][2]*b) / (1 << mixShift)
+}
+
 func (f filterMix) String() string {
+	return "mix:" + f.coeffs()
+}
+
+func (f *filterMix) coeffs() string {
 	return fmt.Sprintf(
-		"mix:[%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f]",
-		f[0][0], f[0][1], f[0][2],
-		f[1][0], f[1][1], f[1][2],
-		f[2][0], f[2][1], f[2][2],
+		"[%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f,%.2f]",
+		mixCoeff(f[0][0]), mixCoeff(f[0][1]), mixCoeff(f[0][2]),
+		mixCoeff(f[1][0]), mixCoeff(f[1][1]), mixCoeff(f[1][2]),
+		mixCoeff(f[2][0]), mixCoeff(f[2][1]), mixCoeff(f[2][2]),
 	)
 }
 
-func newFilterMix(opt *FilterOptions) Filter {
-	return filterMix{
-		[3]float64{2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0},
-		[3]float64{2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0},
-		[3]float64{2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0, 2.0*rand.Float64() - 1.0},
+func randomMix() filterMix {
+	var f filterMix
+	for i := range f {
+		for j := range f[i] {
+			// Exact in float64 as the scale is a power of two
+			f[i][j] = int64((2.0*rand.Float64() - 1.0) * (1 << mixShift))
+		}
 	}
+	return f
+}
+
+func newFilterMix(opt *FilterOptions) Filter {
+	return randomMix()
+}
+
+func mixCoeff(v int64) float64 {
+	return float64(v) / (1 << mixShift)
 }
 
 type filterQuantRGBA [4]uint8
 
 func (f filterQuantRGBA) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	m := [4]uint32{1 << (f[0] + 8), 1 << (f[1] + 8), 1 << (f[2] + 8), 1 << (f[3] + 8)}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			sr = (sr + (m[0] >> 1)) &^ (m[0] - 1)
-			sg = (sg + (m[1] >> 1)) &^ (m[1] - 1)
-			sb = (sb + (m[2] >> 1)) &^ (m[2] - 1)
-			sa = (sa + (m[3] >> 1)) &^ (m[3] - 1)
-			if sr > 0xffff {
-				sr = 0xffff
-			}
-			if sg > 0xffff {
-				sg = 0xffff
-			}
-			if sb > 0xffff {
-				sb = 0xffff
-			}
-			if sa > 0xffff {
-				sa = 0xffff
+func (f filterQuantRGBA) filterSpan(s []NRGBA, x, y int) {
+	m := [4]uint32{1 << (f[0] + 8), 1 << (f[1] + 8), 1 << (f[2] + 8), 1 << (f[3] + 8)}
+	for i := range s {
+		for c := range m {
+			v := (s[i][c] + (m[c] >> 1)) &^ (m[c] - 1)
+			if v > 0xffff {
+				v = 0xffff
 			}
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+			s[i][c] = v
 		}
-		di += dst.Stride
-		si += src.Stride
 	}
 }
 
@@ -570,117 +476,85 @@ func newFilterQuant(opt *FilterOptions) Filter {
 	return filterQuantRGBA{n, n, n, uint8(rand.Intn(8))}
 }
 
-type filterQuantYCCA [4]uint8
+type filterQuantYCCA struct {
+	q      [4]uint8
+	legacy bool
+}
 
 func (f filterQuantYCCA) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	m := [4]uint32{1 << f[0], 1 << f[1], 1 << f[2], 1 << f[3]}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			sY, sCb, sCr := color.RGBToYCbCr(uint8(sr>>8), uint8(sg>>8), uint8(sb>>8))
-			yy := (uint32(sY) + (m[0] >> 1)) &^ (m[0] - 1)
-			cb := (uint32(sCb) + (m[1] >> 1)) &^ (m[1] - 1)
-			cr := (uint32(sCr) + (m[2] >> 1)) &^ (m[2] - 1)
-			aa := (uint32(sa>>8) + (m[3] >> 1)) &^ (m[3] - 1)
-			if yy > 0xff {
-				yy = 0xff
-			}
-			if cb > 0xff {
-				cb = 0xff
-			}
-			if cr > 0xff {
-				cr = 0xff
-			}
-			if aa > 0xff {
-				aa = 0xff
+func (f filterQuantYCCA) filterSpan(s []NRGBA, x, y int) {
+	if f.legacy {
+		f.filterSpan8(s)
+		return
+	}
+	var m [4]uint32
+	for i, q := range f.q {
+		m[i] = 1 << (q + 8)
+	}
+	for i, c := range s {
+		sY, sCb, sCr := rgbToYCC(c[0], c[1], c[2])
+		v := [4]uint32{sY, sCb, sCr, c[3]}
+		for j := range v {
+			v[j] = (v[j] + (m[j] >> 1)) &^ (m[j] - 1)
+			if v[j] > 0xffff {
+				v[j] = 0xffff
 			}
+		}
+		s[i][0], s[i][1], s[i][2] = yccToRGB(v[0], v[1], v[2])
+		s[i][3] = v[3]
+	}
+}
 
-			r8, g8, b8 := color.YCbCrToRGB(uint8(yy), uint8(cb), uint8(cr))
-			sr = (uint32(r8) << 8) | uint32(r8)
-			sg = (uint32(g8) << 8) | uint32(g8)
-			sb = (uint32(b8) << 8) | uint32(b8)
-			sa = (uint32(aa) << 8) | uint32(aa)
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+func (f filterQuantYCCA) filterSpan8(s []NRGBA) {
+	m := [4]uint32{1 << f.q[0], 1 << f.q[1], 1 << f.q[2], 1 << f.q[3]}
+	for i, c := range s {
+		sY, sCb, sCr := rgbToYCC8(c[0], c[1], c[2])
+		yy := (uint32(sY) + (m[0] >> 1)) &^ (m[0] - 1)
+		cb := (uint32(sCb) + (m[1] >> 1)) &^ (m[1] - 1)
+		cr := (uint32(sCr) + (m[2] >> 1)) &^ (m[2] - 1)
+		aa := (uint32(c[3]>>8) + (m[3] >> 1)) &^ (m[3] - 1)
+		if yy > 0xff {
+			yy = 0xff
+		}
+		if cb > 0xff {
+			cb = 0xff
+		}
+		if cr > 0xff {
+			cr = 0xff
 		}
-		di += dst.Stride
-		si += src.Stride
+		if aa > 0xff {
+			aa = 0xff
+		}
+
+		s[i][0], s[i][1], s[i][2] = ycc8ToRGB(uint8(yy), uint8(cb), uint8(cr))
+		s[i][3] = (uint32(aa) << 8) | uint32(aa)
 	}
 }
 
 func (f filterQuantYCCA) String() string {
-	return fmt.Sprintf("qycc:[%d,%d,%d,%d]", f[0], f[1], f[2], f[3])
+	return fmt.Sprintf("qycc:[%d,%d,%d,%d]", f.q[0], f.q[1], f.q[2], f.q[3])
 }
 
 func newFilterQuantYCCA(opt *FilterOptions) Filter {
-	return filterQuantYCCA{uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8))}
+	return filterQuantYCCA{[4]uint8{uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8))}, opt.LegacyYCC}
 }
 
 func newFilterQuantY(opt *FilterOptions) Filter {
-	return filterQuantYCCA{uint8(rand.Intn(8)), 0, 0, 0}
+	return filterQuantYCCA{[4]uint8{uint8(rand.Intn(8)), 0, 0, 0}, opt.LegacyYCC}
 }
 
 type filterInv struct{}
 
 func (f filterInv) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{0xffff - sr, 0xffff - sg, 0xffff - sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterInv) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		s[i] = NRGBA{0xffff - c[0], 0xffff - c[1], 0xffff - c[2], c[3]}
 	}
 }
 
@@ -693,41 +567,12 @@ func newFilterInv(opt *FilterOptions) Filter { return filterInv{} }
 type filterInvRGBAComp uint8
 
 func (f filterInvRGBAComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			v := NRGBA{sr, sg, sb, sa}
-			v[f] = 0xffff - v[f]
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, v)
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterInvRGBAComp) filterSpan(s []NRGBA, x, y int) {
+	for i := range s {
+		s[i][f] = 0xffff - s[i][f]
 	}
 }
 
@@ -743,105 +588,63 @@ func newFilterInvA(opt *FilterOptions) Filter {
 	return filterInvRGBAComp(3)
 }
 
-type filterInvYCCComp uint8
+type filterInvYCCComp struct {
+	c      uint8
+	legacy bool
+}
 
 func (f filterInvYCCComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			sY, sCb, sCr := color.RGBToYCbCr(uint8(sr>>8), uint8(sg>>8), uint8(sb>>8))
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterInvYCCComp) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		if f.legacy {
+			sY, sCb, sCr := rgbToYCC8(c[0], c[1], c[2])
 			v := [3]uint32{uint32(sY), uint32(sCb), uint32(sCr)}
-			v[f] = 0xff - v[f]
-			if f > 0 {
+			v[f.c] = 0xff - v[f.c]
+			if f.c > 0 {
 				// for color components zero = 128
-				v[f]++
-				if v[f] > 0xff {
-					v[f] = 0xff
+				v[f.c]++
+				if v[f.c] > 0xff {
+					v[f.c] = 0xff
 				}
 			}
-
-			r8, g8, b8 := color.YCbCrToRGB(uint8(v[0]), uint8(v[1]), uint8(v[2]))
-			sr = (uint32(r8) << 8) | uint32(r8)
-			sg = (uint32(g8) << 8) | uint32(g8)
-			sb = (uint32(b8) << 8) | uint32(b8)
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+			s[i][0], s[i][1], s[i][2] = ycc8ToRGB(uint8(v[0]), uint8(v[1]), uint8(v[2]))
+			continue
+		}
+		sY, sCb, sCr := rgbToYCC(c[0], c[1], c[2])
+		v := [3]uint32{sY, sCb, sCr}
+		v[f.c] = 0xffff - v[f.c]
+		if f.c > 0 {
+			// for color components zero = 0x8000
+			v[f.c]++
+			if v[f.c] > 0xffff {
+				v[f.c] = 0xffff
+			}
 		}
-		di += dst.Stride
-		si += src.Stride
+		s[i][0], s[i][1], s[i][2] = yccToRGB(v[0], v[1], v[2])
 	}
 }
 
 func (f filterInvYCCComp) String() string {
-	return fmt.Sprintf("iycc:[%d]", f)
+	return fmt.Sprintf("iycc:[%d]", f.c)
 }
 
 func newFilterInvYCCComp(opt *FilterOptions) Filter {
-	return filterInvYCCComp(rand.Intn(3))
+	return filterInvYCCComp{uint8(rand.Intn(3)), opt.LegacyYCC}
 }
 
 type filterGrayscale struct{}
 
 func (f filterGrayscale) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			yy := (19595*sr + 38470*sg + 7471*sb + 1<<15) >> 16
-
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{yy, yy, yy, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
-		}
-		di += dst.Stride
-		si += src.Stride
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterGrayscale) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		yy := (19595*c[0] + 38470*c[1] + 7471*c[2] + 1<<15) >> 16
+		s[i] = NRGBA{yy, yy, yy, c[3]}
 	}
 }
 
@@ -860,88 +663,64 @@ type filterBitRasp struct {
 }
 
 func (f filterBitRasp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
-	m := uint32(f.mask)
-	si := sp.Y*src.Stride + (sp.X << 3)
-	di := dr.Min.Y*dst.Stride + (dr.Min.X << 3)
-	w := dr.Dx()
-	for y := dr.Min.Y; y < dr.Max.Y; y++ {
-		dstSpan := dst.Pix[di : di+(w<<3) : di+(w<<3)]
-		srcSpan := src.Pix[si : si+(w<<3) : si+(w<<3)]
-		var i int
-		for x := dr.Min.X; x < dr.Max.X; x++ {
-			sr := (uint32(srcSpan[i+0]) << 8) | uint32(srcSpan[i+1])
-			sg := (uint32(srcSpan[i+2]) << 8) | uint32(srcSpan[i+3])
-			sb := (uint32(srcSpan[i+4]) << 8) | uint32(srcSpan[i+5])
-			sa := (uint32(srcSpan[i+6]) << 8) | uint32(srcSpan[i+7])
-
-			dr := (uint32(dstSpan[i+0]) << 8) | uint32(dstSpan[i+1])
-			dg := (uint32(dstSpan[i+2]) << 8) | uint32(dstSpan[i+3])
-			db := (uint32(dstSpan[i+4]) << 8) | uint32(dstSpan[i+5])
-			da := (uint32(dstSpan[i+6]) << 8) | uint32(dstSpan[i+7])
-
-			var mix uint32
-			switch f.mode {
-			case 0:
-				mix = uint32(x)
-			case 1:
-				mix = uint32(y)
-			case 2:
-				mix = uint32(y + x)
-			case 3:
-				mix = uint32(y - x)
-			case 4:
-				mix = uint32(y) | uint32(x)
-			case 5:
-				mix = uint32(y) & uint32(x)
-			default:
-				mix = uint32(y) ^ uint32(x)
-			}
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
 
-			mix &= 0xff
-			mix = (mix >> f.ror) | ((mix << (8 - f.ror)) & 0xff)
-			mix = (mix & m) << 8
-
-			aa := sa
-			switch f.op {
-			case 0:
-				sr &= mix
-				sg &= mix
-				sb &= mix
-				aa &= mix
-			case 1:
-				sr ^= mix
-				sg ^= mix
-				sb ^= mix
-				aa ^= mix
-			case 2:
-				sr |= mix
-				sg |= mix
-				sb |= mix
-				aa |= mix
-			default:
-				sr = sr&^m | mix
-				sg = sg&^m | mix
-				sb = sb&^m | mix
-				aa = aa&^m | mix
-			}
-			if f.alpha == 1 {
-				sa = aa
-			}
+func (f filterBitRasp) filterSpan(s []NRGBA, x0, y int) {
+	m := uint32(f.mask)
+	for i := range s {
+		x := x0 + i
+		var mix uint32
+		switch f.mode {
+		case 0:
+			mix = uint32(x)
+		case 1:
+			mix = uint32(y)
+		case 2:
+			mix = uint32(y + x)
+		case 3:
+			mix = uint32(y - x)
+		case 4:
+			mix = uint32(y) | uint32(x)
+		case 5:
+			mix = uint32(y) & uint32(x)
+		default:
+			mix = uint32(y) ^ uint32(x)
+		}
 
-			c := op.Apply(NRGBA{dr, dg, db, da}, NRGBA{sr, sg, sb, sa})
-
-			dstSpan[i+0] = uint8(c[0] >> 8)
-			dstSpan[i+1] = uint8(c[0])
-			dstSpan[i+2] = uint8(c[1] >> 8)
-			dstSpan[i+3] = uint8(c[1])
-			dstSpan[i+4] = uint8(c[2] >> 8)
-			dstSpan[i+5] = uint8(c[2])
-			dstSpan[i+6] = uint8(c[3] >> 8)
-			dstSpan[i+7] = uint8(c[3])
-			i += 8
+		mix &= 0xff
+		mix = (mix >> f.ror) | ((mix << (8 - f.ror)) & 0xff)
+		mix = (mix & m) << 8
+
+		sr, sg, sb, sa := s[i][0], s[i][1], s[i][2], s[i][3]
+		aa := sa
+		switch f.op {
+		case 0:
+			sr &= mix
+			sg &= mix
+			sb &= mix
+			aa &= mix
+		case 1:
+			sr ^= mix
+			sg ^= mix
+			sb ^= mix
+			aa ^= mix
+		case 2:
+			sr |= mix
+			sg |= mix
+			sb |= mix
+			aa |= mix
+		default:
+			sr = sr&^m | mix
+			sg = sg&^m | mix
+			sb = sb&^m | mix
+			aa = aa&^m | mix
 		}
-		di += dst.Stride
-		si += src.Stride
+		if f.alpha == 1 {
+			sa = aa
+		}
+
+		s[i] = NRGBA{sr, sg, sb, sa}
 	}
 }
 
@@ -966,6 +745,308 @@ func newFilterBitRasp(opt *FilterOptions) Filter {
 	return ret
 }
 
+type filterRotHue uint16
+
+func (f filterRotHue) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterRotHue) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		max := max3(c[0], c[1], c[2])
+		ch := max - min3(c[0], c[1], c[2])
+		h := (hue(c[0], c[1], c[2], max, ch) + uint32(f)) & 0xffff
+		s[i][0], s[i][1], s[i][2] = hueToRGB(h, max, ch)
+	}
+}
+
+func (f filterRotHue) String() string {
+	return fmt.Sprintf("huerot:[%d]", uint32(f)*360>>16)
+}
+
+func newFilterRotHue(opt *FilterOptions) Filter {
+	return filterRotHue(rand.Intn(0x10000))
+}
+
+type filterSetHSXComp struct {
+	c, v uint8
+	hsl  bool
+}
+
+func (f filterSetHSXComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterSetHSXComp) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		v := toHSX(c[0], c[1], c[2], f.hsl)
+		v[f.c] = uint32(f.v) * 0x101
+		s[i][0], s[i][1], s[i][2] = fromHSX(v, f.hsl)
+	}
+}
+
+func (f filterSetHSXComp) String() string {
+	return fmt.Sprintf("%s:{%d:%d}", hsxName(f.hsl), f.c, f.v)
+}
+
+func newFilterSetHSVComp(opt *FilterOptions) Filter {
+	return filterSetHSXComp{uint8(rand.Intn(3)), uint8(rand.Intn(256)), false}
+}
+
+func newFilterSetHSLComp(opt *FilterOptions) Filter {
+	return filterSetHSXComp{uint8(rand.Intn(3)), uint8(rand.Intn(256)), true}
+}
+
+type filterPermHSX struct {
+	p   [3]int
+	hsl bool
+}
+
+func (f filterPermHSX) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterPermHSX) filterSpan(s []NRGBA, x, y int) {
+	p := f.p
+	for i, c := range s {
+		v := toHSX(c[0], c[1], c[2], f.hsl)
+		s[i][0], s[i][1], s[i][2] = fromHSX([3]uint32{v[p[0]], v[p[1]], v[p[2]]}, f.hsl)
+	}
+}
+
+func (f filterPermHSX) String() string {
+	return fmt.Sprintf("p%s:[%d,%d,%d]", hsxName(f.hsl), f.p[0], f.p[1], f.p[2])
+}
+
+func newFilterPermHSV(opt *FilterOptions) Filter {
+	p := rand.Perm(3)
+	return filterPermHSX{[3]int{p[0], p[1], p[2]}, false}
+}
+
+func newFilterPermHSL(opt *FilterOptions) Filter {
+	p := rand.Perm(3)
+	return filterPermHSX{[3]int{p[0], p[1], p[2]}, true}
+}
+
+type filterQuantHSXA struct {
+	q   [4]uint8
+	hsl bool
+}
+
+func (f filterQuantHSXA) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterQuantHSXA) filterSpan(s []NRGBA, x, y int) {
+	var m [4]uint32
+	for i, q := range f.q {
+		m[i] = 1 << (q + 8)
+	}
+	for i, c := range s {
+		v := toHSX(c[0], c[1], c[2], f.hsl)
+		a := c[3]
+		// Hue wraps around
+		v[0] = (v[0] + (m[0] >> 1)) &^ (m[0] - 1) & 0xffff
+		for j := 1; j < 3; j++ {
+			if v[j] = (v[j] + (m[j] >> 1)) &^ (m[j] - 1); v[j] > 0xffff {
+				v[j] = 0xffff
+			}
+		}
+		if a = (a + (m[3] >> 1)) &^ (m[3] - 1); a > 0xffff {
+			a = 0xffff
+		}
+		s[i][0], s[i][1], s[i][2] = fromHSX(v, f.hsl)
+		s[i][3] = a
+	}
+}
+
+func (f filterQuantHSXA) String() string {
+	return fmt.Sprintf("q%s:[%d,%d,%d,%d]", hsxName(f.hsl), f.q[0], f.q[1], f.q[2], f.q[3])
+}
+
+func newFilterQuantHSVA(opt *FilterOptions) Filter {
+	return filterQuantHSXA{[4]uint8{uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8))}, false}
+}
+
+func newFilterQuantHSLA(opt *FilterOptions) Filter {
+	return filterQuantHSXA{[4]uint8{uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8))}, true}
+}
+
+type filterInvHSXComp struct {
+	c   uint8
+	hsl bool
+}
+
+func (f filterInvHSXComp) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterInvHSXComp) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		v := toHSX(c[0], c[1], c[2], f.hsl)
+		if f.c == 0 {
+			// Opposite hue
+			v[0] ^= 0x8000
+		} else {
+			v[f.c] = 0xffff - v[f.c]
+		}
+		s[i][0], s[i][1], s[i][2] = fromHSX(v, f.hsl)
+	}
+}
+
+func (f filterInvHSXComp) String() string {
+	return fmt.Sprintf("i%s:[%d]", hsxName(f.hsl), f.c)
+}
+
+func newFilterInvHSVComp(opt *FilterOptions) Filter {
+	return filterInvHSXComp{uint8(rand.Intn(3)), false}
+}
+
+func newFilterInvHSLComp(opt *FilterOptions) Filter {
+	return filterInvHSXComp{uint8(rand.Intn(3)), true}
+}
+
+type filterQuantLab struct {
+	q      [3]uint8
+	linear bool
+}
+
+func (f filterQuantLab) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterQuantLab) filterSpan(s []NRGBA, x, y int) {
+	var m [3]uint32
+	for i, q := range f.q {
+		m[i] = 1 << (q + 8)
+	}
+	for i, c := range s {
+		L, A, B := rgbToOKLab(c[0], c[1], c[2], f.linear)
+		v := [3]uint32{L, A, B}
+		for j := range v {
+			if v[j] = (v[j] + (m[j] >> 1)) &^ (m[j] - 1); v[j] > 0xffff {
+				v[j] = 0xffff
+			}
+		}
+		s[i][0], s[i][1], s[i][2] = oklabToRGB(v[0], v[1], v[2], f.linear)
+	}
+}
+
+func (f filterQuantLab) String() string {
+	return fmt.Sprintf("qlab:[%d,%d,%d]", f.q[0], f.q[1], f.q[2])
+}
+
+func newFilterQuantLab(opt *FilterOptions) Filter {
+	return filterQuantLab{[3]uint8{uint8(rand.Intn(8)), uint8(rand.Intn(8)), uint8(rand.Intn(8))}, opt.LinearLight}
+}
+
+// filterPermLab swaps and mirrors the chroma axes
+type filterPermLab struct {
+	swap       bool
+	negA, negB bool
+	linear     bool
+}
+
+func (f filterPermLab) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterPermLab) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		L, A, B := rgbToOKLab(c[0], c[1], c[2], f.linear)
+		if f.swap {
+			A, B = B, A
+		}
+		if f.negA {
+			A = clamp16(0x10000 - int64(A))
+		}
+		if f.negB {
+			B = clamp16(0x10000 - int64(B))
+		}
+		s[i][0], s[i][1], s[i][2] = oklabToRGB(L, A, B, f.linear)
+	}
+}
+
+func (f filterPermLab) String() string {
+	return fmt.Sprintf("plab:{s:%t,a:%t,b:%t}", f.swap, f.negA, f.negB)
+}
+
+func newFilterPermLab(opt *FilterOptions) Filter {
+	// Skip the identity
+	p := 1 + rand.Intn(7)
+	return filterPermLab{p&1 != 0, p&2 != 0, p&4 != 0, opt.LinearLight}
+}
+
+// filterMixLab is filterMix applied to L, a and b
+type filterMixLab struct {
+	m      filterMix
+	linear bool
+}
+
+func (f filterMixLab) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterMixLab) filterSpan(s []NRGBA, x, y int) {
+	for i, c := range s {
+		L, A, B := rgbToOKLab(c[0], c[1], c[2], f.linear)
+		l, a, b := f.m.mul(int64(L), int64(A)-0x8000, int64(B)-0x8000)
+		s[i][0], s[i][1], s[i][2] = oklabToRGB(clamp16(l), clamp16(a+0x8000), clamp16(b+0x8000), f.linear)
+	}
+}
+
+func (f filterMixLab) String() string {
+	return "mixlab:" + f.m.coeffs()
+}
+
+func newFilterMixLab(opt *FilterOptions) Filter {
+	return filterMixLab{randomMix(), opt.LinearLight}
+}
+
+// filterSRGB runs a filter defined on sRGB values over linear light pixels
+type filterSRGB struct {
+	f interface {
+		Filter
+		spanFilter
+	}
+}
+
+func (f filterSRGB) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	applySpanFilter(f, bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterSRGB) filterSpan(s []NRGBA, x, y int) {
+	toLinear, toSRGB := linearTables()
+	mapSpan(s, toSRGB)
+	f.f.filterSpan(s, x, y)
+	mapSpan(s, toLinear)
+}
+
+func (f filterSRGB) String() string {
+	return f.f.String()
+}
+
+// linearFilter returns the variant of the filter expecting linear light input. The filters moving, mixing
+// and inverting the pixels and the Lab ones stay in linear light. The ones working in YCbCr, HSV or HSL,
+// quantizing or operating on the bits, and the ones setting sRGB colors run in sRGB
+func linearFilter(f Filter) Filter {
+	switch v := f.(type) {
+	case filterSetRGBAComp:
+		// Alpha is the same in both
+		if v.c == 3 {
+			return f
+		}
+	case filterColor, filterSetYCCComp, filterPermYCC, filterQuantRGBA, filterQuantYCCA, filterInvYCCComp,
+		filterBitRasp, filterRotHue, filterSetHSXComp, filterPermHSX, filterQuantHSXA, filterInvHSXComp:
+	default:
+		return f
+	}
+	return filterSRGB{f.(interface {
+		Filter
+		spanFilter
+	})}
+}
+
 var filtersTable = []filterConstructor{
 	FilterColor:       newFilterColor,
 	FilterGray:        newFilterGray,
@@ -989,11 +1070,31 @@ var filtersTable = []filterConstructor{
 	FilterInvYCCComp:  newFilterInvYCCComp,
 	FilterGrayscale:   newFilterGrayscale,
 	FilterBitRasp:     newFilterBitRasp,
+	FilterRotHue:      newFilterRotHue,
+	FilterSetHSVComp:  newFilterSetHSVComp,
+	FilterSetHSLComp:  newFilterSetHSLComp,
+	FilterPermHSV:     newFilterPermHSV,
+	FilterPermHSL:     newFilterPermHSL,
+	FilterQuantHSVA:   newFilterQuantHSVA,
+	FilterQuantHSLA:   newFilterQuantHSLA,
+	FilterInvHSVComp:  newFilterInvHSVComp,
+	FilterInvHSLComp:  newFilterInvHSLComp,
+	FilterQuantLab:    newFilterQuantLab,
+	FilterPermLab:     newFilterPermLab,
+	FilterMixLab:      newFilterMixLab,
+	FilterSort:        newFilterSort,
+	FilterAberr:       newFilterAberr,
+	FilterWave:        newFilterWave,
+	FilterSmear:       newFilterSmear,
 }
 
 func NewRandomizedFilter(f int, opt *FilterOptions) Filter {
 	if f < len(filtersTable) {
-		return filtersTable[f](opt)
+		flt := filtersTable[f](opt)
+		if opt.LinearLight {
+			flt = linearFilter(flt)
+		}
+		return flt
 	}
 	return nil
 }
@@ -1021,6 +1122,22 @@ var filterNames = map[string]int{
 	"invycc":  FilterInvYCCComp,
 	"gs":      FilterGrayscale,
 	"rasp":    FilterBitRasp,
+	"huerot":  FilterRotHue,
+	"hsv":     FilterSetHSVComp,
+	"hsl":     FilterSetHSLComp,
+	"phsv":    FilterPermHSV,
+	"phsl":    FilterPermHSL,
+	"qhsva":   FilterQuantHSVA,
+	"qhsla":   FilterQuantHSLA,
+	"invhsv":  FilterInvHSVComp,
+	"invhsl":  FilterInvHSLComp,
+	"qlab":    FilterQuantLab,
+	"plab":    FilterPermLab,
+	"mixlab":  FilterMixLab,
+	"sort":    FilterSort,
+	"aberr":   FilterAberr,
+	"wave":    FilterWave,
+	"smear":   FilterSmear,
 }
 
 func GetFilterID(name string) int {
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"image"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func benchImages(bpp int) (dst, src *buffer) {
+	r := image.Rect(0, 0, 256, 256)
+	src = new(buffer).reuse(r, bpp)
+	src.loadImage(gradient(r), r)
+	dst = new(buffer).reuse(r, bpp)
+	dst.copyRows(src, 0, r.Dy())
+	return
+}
+
+func BenchmarkFilters(b *testing.B) {
+	for _, depth := range []struct {
+		name string
+		bpp  int
+	}{{"16", 8}, {"8", 4}} {
+		dst, src := benchImages(depth.bpp)
+		for _, name := range FilterNames() {
+			b.Run(name+"/"+depth.name, func(b *testing.B) {
+				rand.Seed(1)
+				f := NewRandomizedFilter(GetFilterID(name), &FilterOptions{BlockSize: 16})
+				b.SetBytes(int64(len(dst.Pix)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					applyFilter(f, dst, dst.Rect, src, image.Point{}, opCompose{})
+				}
+			})
+		}
+	}
+}
+
+func TestSubImage(t *testing.T) {
+	canvas := image.NewNRGBA64(image.Rect(0, 0, 64, 48))
+	draw.Draw(canvas, canvas.Bounds(), gradient(canvas.Bounds()), image.Point{}, draw.Src)
+	dr := image.Rect(13, 7, 45, 33)
+	sp := image.Pt(3, 11)
+
+	for _, name := range FilterNames() {
+		rand.Seed(1)
+		f := NewRandomizedFilter(GetFilterID(name), &FilterOptions{BlockSize: 16})
+
+		// Reference on standalone images
+		ref := image.NewNRGBA64(dr)
+		draw.Draw(ref, dr, canvas, dr.Min, draw.Src)
+		refSrc := image.NewNRGBA64(image.Rect(0, 0, dr.Dx(), dr.Dy()))
+		draw.Draw(refSrc, refSrc.Bounds(), canvas, sp, draw.Src)
+		f.Apply(ref, dr, refSrc, image.Point{}, opCompose{})
+
+		img := image.NewNRGBA64(canvas.Bounds())
+		copy(img.Pix, canvas.Pix)
+		dst := img.SubImage(dr).(*image.NRGBA64)
+		src := canvas.SubImage(image.Rectangle{Min: sp, Max: sp.Add(dr.Size())}).(*image.NRGBA64)
+		f.Apply(dst, dr, src, sp, opCompose{})
+
+		for y := 0; y < img.Rect.Dy(); y++ {
+			for x := 0; x < img.Rect.Dx(); x++ {
+				expected := canvas.NRGBA64At(x, y)
+				if image.Pt(x, y).In(dr) {
+					expected = ref.NRGBA64At(x, y)
+				}
+				if c := img.NRGBA64At(x, y); c != expected {
+					t.Fatalf("%v: pixel %d,%d is %v, expected %v", f, x, y, c, expected)
+				}
+			}
+		}
+	}
+}
+
+// filterGradient runs the block filter over the gradient image split into blocks of bs pixels.
+// It returns the source and the result
+func filterGradient(f blockFilter, r image.Rectangle, bs int) (src, dst *buffer) {
+	src = new(buffer).reuse(r, 8)
+	src.loadImage(gradient(r), r)
+	dst = new(buffer).reuse(r, 8)
+	for y := r.Min.Y; y < r.Max.Y; y += bs {
+		for x := r.Min.X; x < r.Max.X; x += bs {
+			dr := image.Rect(x, y, x+bs, y+bs).Intersect(r)
+			f.filterBlock(dst, dr, src, dr.Min, opReplace{})
+		}
+	}
+	return src, dst
+}
+
+func pixelAt(b *buffer, x, y int) NRGBA {
+	var c [1]NRGBA
+	b.load(c[:], x, y)
+	return c[0]
+}
+
+func rgb8(r, g, b uint8) NRGBA {
+	return NRGBA{uint32(r) * 0x101, uint32(g) * 0x101, uint32(b) * 0x101, 0xffff}
+}
+
+// near reports whether the colors match within the 16 bit Lab round trip error
+func near(c, expected NRGBA) bool {
+	for i := range c {
+		if d := int(c[i]) - int(expected[i]); d < -32 || d > 32 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLabFilters(t *testing.T) {
+	// OKLab of pure red is L 0.628, a 0.225, b 0.126. The expected values are computed
+	// with the reference matrices and clipped to sRGB
+	red := NRGBA{0xffff, 0, 0, 0xffff}
+	var lonly filterMix
+	lonly[0][0] = 1 << mixShift
+	tests := []struct {
+		f   spanFilter
+		in  NRGBA
+		out NRGBA
+	}{
+		// The coarsest step takes L and the chroma to the middle: gray of L 0.5
+		{filterQuantLab{q: [3]uint8{7, 7, 7}}, red, NRGBA{0x6379, 0x6379, 0x6379, 0xffff}},
+		// Swapped chroma: L 0.628, a 0.126, b 0.225
+		{filterPermLab{swap: true}, red, NRGBA{0xeee6, 0x494a, 0, 0xffff}},
+		// Opposite chroma: L 0.628, a -0.225, b -0.126
+		{filterPermLab{negA: true, negB: true}, red, NRGBA{0, 0xa9d5, 0xdbaa, 0xffff}},
+		// Chroma dropped: gray of L 0.628
+		{filterMixLab{m: lonly}, red, NRGBA{0x88e4, 0x88e4, 0x88e4, 0xffff}},
+	}
+	for _, tt := range tests {
+		s := []NRGBA{tt.in}
+		tt.f.filterSpan(s, 0, 0)
+		if !near(s[0], tt.out) {
+			t.Errorf("%v(%#x) = %#x, expected %#x", tt.f, tt.in, s[0], tt.out)
+		}
+	}
+}
@@ -7,8 +7,6 @@ import (
 	"math/rand"
 	"runtime"
 	"strings"
-	"sync"
-	"unsafe"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/image/draw"
@@ -25,6 +23,48 @@ type Options struct {
 	Filters        []string
 	Ops            []string
 	Threads        int
+	PadEdges       bool
+	PixelShiftX    int
+	PixelShiftY    int
+	WrapEdges      bool
+	FeatherBlock   int
+	FeatherRun     int
+	FeatherCurve   int
+
+	Placement         int
+	PlacementCenter   float64
+	PlacementSpread   float64
+	PlacementClusters int
+
+	Content     int
+	ContentFlat bool
+	// The block scores are raised to ContentStrength. Zero means 1
+	ContentStrength float64
+
+	// Range of the wave filter amplitude in pixels and frequency in periods per row. A zero range picks them
+	// relative to BlockSize, a minimum without a maximum is an error
+	MinWaveAmp, MaxWaveAmp   int
+	MinWaveFreq, MaxWaveFreq float64
+
+	// If Opacity is set the opacity of every operation of a chain is picked between MinOpacity and MaxOpacity
+	// and the operation result is mixed with the image accordingly. Otherwise the operations are opaque
+	Opacity    bool
+	MinOpacity float64
+	MaxOpacity float64
+
+	Output16  bool // Return *image.NRGBA64 instead of *image.NRGBA
+	LegacyYCC bool // Convert to YCbCr in 8 bit as the earlier versions did. The bitwise YCbCr ops always use 16 bit
+
+	// Process in linear light instead of sRGB so blending behaves like mixing light. Implies 16 bit processing.
+	// The YCbCr, HSV, HSL, quantizing and bitwise filters and operations still see sRGB values.
+	// 16 bit linear light is coarse near black: 8 bit input survives the round trip but 16 bit input
+	// may move by up to 13 units there
+	LinearLight bool
+
+	// Keep the working images in temporary files if they don't fit into MemoryBudget bytes. The source image
+	// is only read at the start and can be collected while processing if the caller doesn't hold it. Apply still
+	// allocates the result, use ApplyFunc to read it from the temporary files instead. Zero means no limit
+	MemoryBudget int64
 }
 
 var (
@@ -32,27 +72,351 @@ var (
 	ErrImageTooSmall = errors.New("image is too small")
 )
 
-func copyImage(dst, src *image.NRGBA64) {
-	dst.Rect = src.Rect
-	dst.Stride = src.Stride
-	copy(dst.Pix, src.Pix)
+// Engine keeps the working buffers between calls so repeated runs on images of the same or
+// smaller size don't allocate them again. ApplyInto doesn't allocate any image size memory then while Apply
+// still allocates the image it returns. An Engine must not be used concurrently, use one Engine per goroutine instead.
+// With more than one thread the Engine starts worker goroutines on the first call and keeps them between calls.
+// They are not stopped when the Engine is dropped so call Close once the Engine is no longer needed
+type Engine struct {
+	src, dst, tmp0, tmp1 buffer
+	filters              []Filter
+	ops                  []Operation
+	pass                 pass
+	segPass              pass // Gathering and band passes
+	bands                []band
+	workers              *workers
+}
+
+// Close stops the worker goroutines. It must be called before dropping an Engine, otherwise the goroutines leak.
+// The Engine may still be used afterwards
+func (e *Engine) Close() {
+	if e.workers != nil {
+		e.workers.close()
+		e.workers = nil
+	}
+}
+
+func (e *Engine) buffers() [4]*buffer {
+	return [4]*buffer{&e.src, &e.dst, &e.tmp0, &e.tmp1}
+}
+
+// spill moves the working images to stripe stores sharing the memory budget
+func (e *Engine) spill(r image.Rectangle, bpp int, budget int64) error {
+	for _, b := range e.buffers() {
+		if err := b.spill(r, bpp, budget/4); err != nil {
+			e.release()
+			return err
+		}
+	}
+	return nil
+}
+
+// release closes the stripe stores and returns the first I/O error if any
+func (e *Engine) release() error {
+	var err error
+	for _, b := range e.buffers() {
+		if berr := b.release(); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
+// pass is a single filter of the chain applied to the segment
+type pass struct {
+	filter    Filter
+	op        Operation
+	dst, src  *buffer
+	orig      *buffer
+	bounds    image.Rectangle
+	blockSize int
+	blocksX   int
+	blocks    int
+	segStart  int
+	segBlocks int
+	shift     int
+	offset    image.Point
+	first     bool
+	wrap      bool
+	feather   *featherer
+	bands     []band // Bands of the segment processed instead of the blocks
+	seg       image.Rectangle
+	chunk     int
+	next      int32
+}
+
+// band is a block row or a block column of the segment
+type band struct {
+	r    image.Rectangle
+	b, n int // First block and the number of blocks
+	step int // Distance between the blocks
+}
+
+func (p *pass) run(b, ln int) {
+	if log.IsLevelEnabled(log.TraceLevel) {
+		log.Tracef("block: %d..%d, filter: %v", b, b+ln, p.filter)
+	}
+	if p.bands != nil {
+		p.runBands(b, ln)
+		return
+	}
+	// Apply block by block
+	for ; ln > 0; b, ln = b+1, ln-1 {
+		sb := b
+		if p.first {
+			// Apply shift
+			sb = (b + p.shift) % p.blocks
+		}
+
+		dr := blockRect(b, p.blocksX, p.blockSize, p.bounds)
+		sp := blockRect(sb, p.blocksX, p.blockSize, p.bounds).Min
+		if p.first {
+			sp = sp.Add(p.offset)
+		}
+		if p.wrap {
+			applyWrapped(p.filter, p.dst, dr, p.src, sp, p.op, p.bounds)
+		} else {
+			applyFilter(p.filter, p.dst, dr, p.src, clipSource(sp, dr.Size(), p.bounds), p.op)
+		}
+
+		if p.feather != nil {
+			p.featherBlock(b, dr)
+		}
+	}
+}
+
+func (p *pass) runBands(i, ln int) {
+	f := p.filter.(segmentFilter)
+	for ; ln > 0; i, ln = i+1, ln-1 {
+		bd := &p.bands[i]
+		f.filterBand(p.dst, bd.r, p.src, p.seg, p.op)
+		if p.feather != nil {
+			for b, n := bd.b, bd.n; n > 0; b, n = b+bd.step, n-1 {
+				p.featherBlock(b, blockRect(b, p.blocksX, p.blockSize, p.bounds))
+			}
+		}
+	}
+}
+
+// featherBlock blends the block with the original
+func (p *pass) featherBlock(b int, dr image.Rectangle) {
+	start := p.runPos(p.segStart)
+	p.feather.apply(p.dst, dr, p.orig, p.runPos(b)-start, p.runPos(p.segStart+p.segBlocks)-start)
 }
 
-func clearStripe(img *image.NRGBA64, y0, y1 int) {
-	s64 := (*[(1<<31 - 1) >> 3]uint64)(unsafe.Pointer(&img.Pix[0]))[:len(img.Pix)>>3]
-	stride := img.Stride >> 3
-	stripe := s64[y0*stride : y1*stride : y1*stride]
-	for i := range stripe {
-		stripe[i] = 0
+// jobs returns the range of the blocks or the bands to process
+func (p *pass) jobs() (int, int) {
+	if p.bands != nil {
+		return 0, len(p.bands)
+	}
+	return p.segStart, p.segStart + p.segBlocks
+}
+
+// segmentBands appends the block rows or the block columns of the segment to bands and returns
+// them along with the bounding rectangle of the segment
+func (p *pass) segmentBands(vertical bool, bands []band) ([]band, image.Rectangle) {
+	last := p.segStart + p.segBlocks - 1
+	r0, c0 := p.segStart/p.blocksX, p.segStart%p.blocksX
+	r1, c1 := last/p.blocksX, last%p.blocksX
+	if vertical {
+		for c := 0; c < p.blocksX; c++ {
+			top, bottom := r0, r1
+			if c < c0 {
+				top++
+			}
+			if c > c1 {
+				bottom--
+			}
+			if top <= bottom {
+				bands = append(bands, p.band(top*p.blocksX+c, bottom-top+1, p.blocksX))
+			}
+		}
+	} else {
+		for r := r0; r <= r1; r++ {
+			left, right := 0, p.blocksX-1
+			if r == r0 {
+				left = c0
+			}
+			if r == r1 {
+				right = c1
+			}
+			bands = append(bands, p.band(r*p.blocksX+left, right-left+1, 1))
+		}
+	}
+	var seg image.Rectangle
+	for i := range bands {
+		seg = seg.Union(bands[i].r)
+	}
+	return bands, seg
+}
+
+func (p *pass) band(b, n, step int) band {
+	r := blockRect(b, p.blocksX, p.blockSize, p.bounds)
+	r = r.Union(blockRect(b+(n-1)*step, p.blocksX, p.blockSize, p.bounds))
+	return band{r: r, b: b, n: n, step: step}
+}
+
+// runSegment applies the filter band by band. The first filter of the chain gathers the shifted
+// source into scratch beforehand so the bands read a contiguous area
+func (e *Engine) runSegment(ps *pass, vertical bool, scratch *buffer) {
+	sp := &e.segPass
+	src := ps.src
+	if ps.first {
+		*sp = *ps
+		sp.filter, sp.op, sp.dst, sp.feather = filterSource{}, opReplace{}, scratch, nil
+		e.workers.run(sp)
+		src = scratch
+	}
+	*sp = *ps
+	sp.src, sp.first = src, false
+	e.bands, sp.seg = ps.segmentBands(vertical, e.bands[:0])
+	sp.bands, sp.chunk = e.bands, 1
+	e.workers.run(sp)
+}
+
+// runPos returns the total width of the blocks preceding b in raster order so the run continues
+// on the next block row and the clipped edge blocks count with their actual width
+func (p *pass) runPos(b int) int {
+	return b/p.blocksX*p.bounds.Dx() + b%p.blocksX*p.blockSize
+}
+
+func blockRect(b, blocksX, blockSize int, bounds image.Rectangle) image.Rectangle {
+	x, y := (b%blocksX)*blockSize, (b/blocksX)*blockSize
+	return image.Rect(x, y, x+blockSize, y+blockSize).Intersect(bounds)
+}
+
+// clipSource moves the source point back so the whole window fits into the image
+func clipSource(sp, sz image.Point, bounds image.Rectangle) image.Point {
+	if sp.X+sz.X > bounds.Max.X {
+		sp.X = bounds.Max.X - sz.X
+	}
+	if sp.Y+sz.Y > bounds.Max.Y {
+		sp.Y = bounds.Max.Y - sz.Y
+	}
+	if sp.X < bounds.Min.X {
+		sp.X = bounds.Min.X
+	}
+	if sp.Y < bounds.Min.Y {
+		sp.Y = bounds.Min.Y
+	}
+	return sp
+}
+
+func wrap(v, n int) int {
+	v %= n
+	if v < 0 {
+		v += n
+	}
+	return v
+}
+
+// applyWrapped splits the source window at the image edges and wraps it around
+func applyWrapped(f Filter, dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation, bounds image.Rectangle) {
+	w, h := bounds.Dx(), bounds.Dy()
+	sy := wrap(sp.Y-bounds.Min.Y, h)
+	for y := dr.Min.Y; y < dr.Max.Y; {
+		dy := dr.Max.Y - y
+		if dy > h-sy {
+			dy = h - sy
+		}
+		sx := wrap(sp.X-bounds.Min.X, w)
+		for x := dr.Min.X; x < dr.Max.X; {
+			dx := dr.Max.X - x
+			if dx > w-sx {
+				dx = w - sx
+			}
+			applyFilter(f, dst, image.Rect(x, y, x+dx, y+dy), src, image.Pt(bounds.Min.X+sx, bounds.Min.Y+sy), op)
+			x += dx
+			sx = 0
+		}
+		y += dy
+		sy = 0
 	}
 }
 
 func (opt *Options) Apply(img image.Image) (image.Image, error) {
+	var e Engine
+	defer e.Close()
+	return e.Apply(opt, img)
+}
+
+// ApplyInto processes the area r of src and writes the result to the same area of dst. dst may be src itself
+func (opt *Options) ApplyInto(dst draw.Image, r image.Rectangle, src image.Image) error {
+	var e Engine
+	defer e.Close()
+	return e.ApplyInto(opt, dst, r, src)
+}
+
+// Apply processes the image and returns the result in a newly allocated image
+func (e *Engine) Apply(opt *Options, img image.Image) (image.Image, error) {
+	defer e.release()
+	buf, err := e.process(opt, img, img.Bounds(), opt.Output16 || Is16Bit(img))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret draw.Image
+	r := image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+	if opt.Output16 {
+		ret = image.NewNRGBA64(r)
+	} else {
+		// Convert to 8bpp
+		ret = image.NewNRGBA(r)
+	}
+	buf.storeImage(ret, r)
+
+	if err := e.release(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ApplyFunc processes the image and calls fn with the result. The result is read from the working images
+// without copying so it's valid only until fn returns and it must not be used concurrently
+func (e *Engine) ApplyFunc(opt *Options, img image.Image, fn func(image.Image) error) error {
+	defer e.release()
+	r := img.Bounds()
+	buf, err := e.process(opt, img, r, opt.Output16 || Is16Bit(img))
+	if err != nil {
+		return err
+	}
+	err = fn(buf.image(r.Dx(), r.Dy(), opt.Output16))
+	if rerr := e.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// ApplyInto processes the area r of src and writes the result to the same area of dst
+func (e *Engine) ApplyInto(opt *Options, dst draw.Image, r image.Rectangle, src image.Image) error {
+	r = r.Intersect(src.Bounds()).Intersect(dst.Bounds())
+	defer e.release()
+	buf, err := e.process(opt, src, r, opt.Output16 || Is16Bit(src) || Is16Bit(dst))
+	if err != nil {
+		return err
+	}
+	buf.storeImage(dst, r)
+	return e.release()
+}
+
+// process glitches the area r of img and returns the resulting working image
+func (e *Engine) process(opt *Options, img image.Image, r image.Rectangle, deep bool) (*buffer, error) {
 	if opt.BlockSize <= 0 ||
 		opt.MinSegmentSize > 1 || opt.MaxSegmentSize > 1 ||
 		opt.MinSegmentSize < 0 || opt.MaxSegmentSize < opt.MinSegmentSize ||
 		opt.MinFilters <= 0 || opt.MaxFilters < opt.MinFilters ||
-		opt.MinIterations < 0 || opt.MaxIterations < opt.MinIterations {
+		opt.MinIterations < 0 || opt.MaxIterations < opt.MinIterations ||
+		opt.PixelShiftX < 0 || opt.PixelShiftY < 0 ||
+		opt.FeatherBlock < 0 || opt.FeatherRun < 0 ||
+		opt.FeatherCurve < 0 || opt.FeatherCurve >= FeatherNumCurves ||
+		opt.Placement < 0 || opt.Placement >= PlacementNumModes ||
+		!(opt.PlacementCenter >= 0 && opt.PlacementCenter <= 1) || !(opt.PlacementSpread >= 0) ||
+		opt.Placement == PlacementCluster && opt.PlacementClusters <= 0 ||
+		opt.Content < 0 || opt.Content >= ContentNumMetrics || opt.ContentStrength < 0 ||
+		opt.MinWaveAmp < 0 || opt.MaxWaveAmp < opt.MinWaveAmp ||
+		!(opt.MinWaveFreq == 0 && opt.MaxWaveFreq == 0 || opt.MinWaveFreq > 0 && opt.MaxWaveFreq >= opt.MinWaveFreq) ||
+		opt.Opacity && !(opt.MinOpacity >= 0 && opt.MaxOpacity <= 1 && opt.MaxOpacity >= opt.MinOpacity) ||
+		opt.MemoryBudget < 0 {
 		return nil, ErrOptions
 	}
 
@@ -78,37 +442,93 @@ func (opt *Options) Apply(img image.Image) (image.Image, error) {
 	}
 	log.Tracef("threadsNum: %d", threadsNum)
 
-	imageW := img.Bounds().Dx()
-	imageH := img.Bounds().Dy()
+	if e.workers != nil && e.workers.n != threadsNum-1 {
+		e.Close()
+	}
+	if e.workers == nil && threadsNum > 1 {
+		e.workers = newWorkers(threadsNum - 1)
+	}
 
-	src := image.NewNRGBA64(image.Rect(0, 0, imageW, imageH))
-	dst := image.NewNRGBA64(image.Rect(0, 0, imageW, imageH))
-	tmp0 := image.NewNRGBA64(image.Rect(0, 0, imageW, imageH))
-	tmp1 := image.NewNRGBA64(image.Rect(0, 0, imageW, imageH))
-	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	imageW := r.Dx()
+	imageH := r.Dy()
+	if imageW == 0 || imageH == 0 {
+		return nil, ErrImageTooSmall
+	}
 
-	iterations := opt.MinIterations + rand.Intn(opt.MaxIterations-opt.MinIterations+1)
-	for itn := 0; itn < iterations; itn++ {
-		// Copy back
-		copyImage(src, dst)
+	// Clip partial edge blocks or pad the image up to the block grid
+	bufW, bufH := imageW, imageH
+	if opt.PadEdges {
+		bufW = (imageW + opt.BlockSize - 1) / opt.BlockSize * opt.BlockSize
+		bufH = (imageH + opt.BlockSize - 1) / opt.BlockSize * opt.BlockSize
+	}
+	bounds := image.Rect(0, 0, bufW, bufH)
 
-		blocksX := imageW / opt.BlockSize
-		blocksY := imageH / opt.BlockSize
-		blocks := blocksX * blocksY
-		if blocks == 0 {
-			return nil, ErrImageTooSmall
+	// 8-bit images are processed natively
+	bpp := 4
+	if deep || opt.LinearLight {
+		bpp = 8
+	}
+	src, dst, tmp0, tmp1 := &e.src, &e.dst, &e.tmp0, &e.tmp1
+	if opt.MemoryBudget > 0 && 4*int64(bufW)*int64(bufH)*int64(bpp) > opt.MemoryBudget {
+		// Process stripe by stripe
+		if err := e.spill(bounds, bpp, opt.MemoryBudget); err != nil {
+			return nil, err
 		}
-
-		if float64(blocks)*opt.MinSegmentSize < 1 {
-			return nil, ErrImageTooSmall
+	} else {
+		for _, b := range e.buffers() {
+			b.reuse(bounds, bpp)
 		}
+	}
+	dst.loadImage(img, r)
+	if opt.PadEdges {
+		dst.padEdges(imageW, imageH)
+	}
+	src.copyRows(dst, 0, bufH)
+
+	blocksX := (bufW + opt.BlockSize - 1) / opt.BlockSize
+	blocksY := (bufH + opt.BlockSize - 1) / opt.BlockSize
+	blocks := blocksX * blocksY
+	if float64(blocks)*opt.MinSegmentSize < 1 {
+		return nil, ErrImageTooSmall
+	}
+
+	feather := newFeatherer(opt.FeatherBlock, opt.FeatherRun, opt.FeatherCurve)
+	placer := newPlacer(opt)
+	if opt.Content != ContentNone {
+		placer.setScores(blockScores(dst, opt.Content, blocksX, blocks, opt.BlockSize), opt.ContentFlat, opt.ContentStrength)
+	}
+
+	var toSRGB []uint16
+	if opt.LinearLight {
+		var toLinear []uint16
+		toLinear, toSRGB = linearTables()
+		dst.mapColor(0, bufH, toLinear)
+		src.mapColor(0, bufH, toLinear)
+	}
+	// The intermediate images match the image outside of the segment so the filters further down the chain
+	// sample the image around their blocks
+	tmp0.copyRows(dst, 0, bufH)
+	tmp1.copyRows(dst, 0, bufH)
 
-		p := opt.MinSegmentSize + rand.Float64()*(opt.MaxSegmentSize-opt.MinSegmentSize)
+	fo := &FilterOptions{
+		BlockSize:   opt.BlockSize,
+		LegacyYCC:   opt.LegacyYCC,
+		LinearLight: opt.LinearLight,
+		WrapEdges:   opt.WrapEdges,
+		MinWaveAmp:  opt.MinWaveAmp,
+		MaxWaveAmp:  opt.MaxWaveAmp,
+		MinWaveFreq: opt.MinWaveFreq,
+		MaxWaveFreq: opt.MaxWaveFreq,
+	}
+
+	iterations := opt.MinIterations + rand.Intn(opt.MaxIterations-opt.MinIterations+1)
+	for itn := 0; itn < iterations; itn++ {
+		p := opt.MinSegmentSize + float64(rand.Float64()*(opt.MaxSegmentSize-opt.MinSegmentSize))
 		segBlocks := int(float64(blocks) * p)
 		if segBlocks == 0 {
 			continue
 		}
-		segStart := rand.Intn(blocks - segBlocks + 1)
+		segStart := placer.place(blocks, segBlocks)
 
 		var segShift int
 		if rand.Intn(2) == 1 {
@@ -116,21 +536,26 @@ func (opt *Options) Apply(img image.Image) (image.Image, error) {
 			segShift = rand.Intn(blocks)
 		}
 
-		blocksPerThread := (segBlocks + threadsNum - 1) / threadsNum
+		// Sub-block offset
+		var pixShift image.Point
+		if opt.PixelShiftX > 0 {
+			pixShift.X = rand.Intn(2*opt.PixelShiftX+1) - opt.PixelShiftX
+		}
+		if opt.PixelShiftY > 0 {
+			pixShift.Y = rand.Intn(2*opt.PixelShiftY+1) - opt.PixelShiftY
+		}
 
-		// Clear intermediate images
-		stripeY0 := (segStart / blocksX) * opt.BlockSize
-		stripeY1 := ((segStart+segBlocks)/blocksX + 1) * opt.BlockSize
-		clearStripe(tmp0, stripeY0, stripeY1)
-		clearStripe(tmp1, stripeY0, stripeY1)
+		stripeY0 := blockRect(segStart, blocksX, opt.BlockSize, bounds).Min.Y
+		stripeY1 := blockRect(segStart+segBlocks-1, blocksX, opt.BlockSize, bounds).Max.Y
 
 		filtersNum := opt.MinFilters + rand.Intn(opt.MaxFilters-opt.MinFilters+1)
-		filters := make([]Filter, filtersNum)
-
-		fo := FilterOptions{
-			BlockSize: opt.BlockSize,
-			Reference: src,
+		if cap(e.filters) < filtersNum {
+			e.filters = make([]Filter, filtersNum)
+			e.ops = make([]Operation, filtersNum)
 		}
+		filters := e.filters[:filtersNum]
+		ops := e.ops[:filtersNum]
+
 		for i := range filters {
 			var n int
 			if opt.Filters != nil {
@@ -139,12 +564,11 @@ func (opt *Options) Apply(img image.Image) (image.Image, error) {
 			} else {
 				n = rand.Intn(FilterNumFilters)
 			}
-			if filters[i] = NewRandomizedFilter(n, &fo); filters[i] == nil {
+			if filters[i] = NewRandomizedFilter(n, fo); filters[i] == nil {
 				return nil, ErrOptions
 			}
 		}
 
-		ops := make([]Operation, filtersNum)
 		for i := 0; i < filtersNum; i++ {
 			if i < filtersNum-1 {
 				ops[i] = GetOp(OpReplace)
@@ -155,6 +579,19 @@ func (opt *Options) Apply(img image.Image) (image.Image, error) {
 				opn := rand.Intn(OpNumOps)
 				ops[i] = GetOp(opn)
 			}
+			if opt.LegacyYCC {
+				ops[i] = legacyYCC(ops[i])
+			}
+			if opt.LinearLight {
+				ops[i] = linearLight(ops[i])
+			}
+			if opt.Opacity {
+				a := opt.MaxOpacity
+				if opt.MinOpacity < opt.MaxOpacity {
+					a = opt.MinOpacity + float64(rand.Float64()*(opt.MaxOpacity-opt.MinOpacity))
+				}
+				ops[i] = withOpacity(ops[i], a)
+			}
 		}
 
 		if log.IsLevelEnabled(log.DebugLevel) {
@@ -162,59 +599,68 @@ func (opt *Options) Apply(img image.Image) (image.Image, error) {
 			for i, f := range filters {
 				fs[i] = fmt.Sprintf("{%v,%v}", f, ops[i])
 			}
-			log.Debugf("iter: %d, shift: %d, filters: [%s]", itn, segShift, strings.Join(fs, ","))
+			log.Debugf("iter: %d, shift: %d, offset: %v, filters: [%s]", itn, segShift, pixShift, strings.Join(fs, ","))
+		}
+
+		ps := &e.pass
+		*ps = pass{
+			orig:      src,
+			bounds:    bounds,
+			blockSize: opt.BlockSize,
+			blocksX:   blocksX,
+			blocks:    blocks,
+			segStart:  segStart,
+			segBlocks: segBlocks,
+			shift:     segShift,
+			offset:    pixShift,
+			wrap:      opt.WrapEdges,
+			chunk:     segBlocks,
+		}
+		if threadsNum > 1 {
+			ps.chunk = segBlocks / (threadsNum * chunksPerThread)
+			if ps.chunk == 0 {
+				ps.chunk = 1
+			}
 		}
 
 		for fc := 0; fc < filtersNum; fc++ {
-			var (
-				ss, dd *image.NRGBA64
-			)
+			ps.filter, ps.op = filters[fc], ops[fc]
+			ps.first = fc == 0
 			if fc == 0 {
-				ss = src
+				ps.src = src
 			} else {
-				ss = tmp0
+				ps.src = tmp0
 			}
 			if fc < filtersNum-1 {
-				dd = tmp1
+				ps.dst = tmp1
+				ps.feather = nil
+				if _, ok := ps.op.(opOpacity); ok {
+					// The intermediate results are mixed with the image too
+					tmp1.copyRows(dst, stripeY0, stripeY1)
+				}
 			} else {
-				dd = dst
+				ps.dst = dst
+				ps.feather = feather
 			}
 
-			var wg sync.WaitGroup
-			delta := blocksPerThread
-			for tlen, tstart := segBlocks, segStart; tlen > 0; tlen, tstart = tlen-delta, tstart+delta {
-				if delta > tlen {
-					delta = tlen
-				}
-				wg.Add(1)
-				worker := func(b, ln int) {
-					log.Tracef("block: %d..%d, filter: %v", b, b+ln, filters[fc])
-					// Apply block by block
-					for ; ln > 0; b, ln = b+1, ln-1 {
-						sb := b
-						if fc == 0 {
-							// Apply shift
-							sb = (b + segShift) % blocks
-						}
-
-						dx, dy := (b%blocksX)*opt.BlockSize, (b/blocksX)*opt.BlockSize
-						dr := image.Rect(dx, dy, dx+opt.BlockSize, dy+opt.BlockSize)
-						sp := image.Point{(sb % blocksX) * opt.BlockSize, (sb / blocksX) * opt.BlockSize}
-						filters[fc].Apply(dd, dr, ss, sp, ops[fc])
-					}
-					wg.Done()
-				}
-				go worker(tstart, delta)
+			if on, vertical := segmentMode(ps.filter); on {
+				// tmp0 is free during the first pass
+				e.runSegment(ps, vertical, tmp0)
+			} else {
+				e.workers.run(ps)
 			}
-			wg.Wait()
 
 			tmp0, tmp1 = tmp1, tmp0
 		}
-	}
 
-	// Convert to 8bpp
-	ret := image.NewNRGBA(image.Rect(0, 0, dst.Bounds().Dx(), dst.Bounds().Dy()))
-	draw.Draw(ret, ret.Bounds(), dst, dst.Bounds().Min, draw.Src)
+		// Copy back
+		src.copyRows(dst, stripeY0, stripeY1)
+		tmp0.copyRows(dst, stripeY0, stripeY1)
+		tmp1.copyRows(dst, stripeY0, stripeY1)
+	}
 
-	return ret, nil
+	if toSRGB != nil {
+		dst.mapColor(0, bufH, toSRGB)
+	}
+	return dst, nil
 }
@@ -0,0 +1,135 @@
+package engine
+
+// HSV and HSL with 16 bit components. Hue covers the full circle with 0x10000 being 360 degrees
+
+func max3(r, g, b uint32) uint32 {
+	if g > r {
+		r = g
+	}
+	if b > r {
+		r = b
+	}
+	return r
+}
+
+func min3(r, g, b uint32) uint32 {
+	if g < r {
+		r = g
+	}
+	if b < r {
+		r = b
+	}
+	return r
+}
+
+// hue returns the hue of the color with the given maximum and chroma
+func hue(r, g, b, max, c uint32) uint32 {
+	if c == 0 {
+		return 0
+	}
+	// Six sectors 0x10000 each
+	var h int64
+	switch max {
+	case r:
+		h = (int64(g) - int64(b)) << 16 / int64(c)
+		if h < 0 {
+			h += 6 << 16
+		}
+	case g:
+		h = 2<<16 + (int64(b)-int64(r))<<16/int64(c)
+	default:
+		h = 4<<16 + (int64(r)-int64(g))<<16/int64(c)
+	}
+	return uint32((h+3)/6) & 0xffff
+}
+
+// hueToRGB returns the color with the given hue, maximum and chroma
+func hueToRGB(h, max, c uint32) (uint32, uint32, uint32) {
+	h6 := h * 6
+	f := (c*(h6&0xffff) + 0x8000) >> 16
+	min := max - c
+	switch h6 >> 16 {
+	case 0:
+		return max, min + f, min
+	case 1:
+		return max - f, max, min
+	case 2:
+		return min, max, min + f
+	case 3:
+		return min, max - f, max
+	case 4:
+		return min + f, min, max
+	default:
+		return max, min, max - f
+	}
+}
+
+func rgbToHSV(r, g, b uint32) (uint32, uint32, uint32) {
+	max := max3(r, g, b)
+	c := max - min3(r, g, b)
+	var s uint32
+	if max != 0 {
+		s = (c*0xffff + max>>1) / max
+	}
+	return hue(r, g, b, max, c), s, max
+}
+
+func hsvToRGB(h, s, v uint32) (uint32, uint32, uint32) {
+	return hueToRGB(h, v, (v*s+0x7fff)/0xffff)
+}
+
+func rgbToHSL(r, g, b uint32) (uint32, uint32, uint32) {
+	max := max3(r, g, b)
+	min := min3(r, g, b)
+	c := max - min
+	var s uint32
+	if c != 0 {
+		// Chroma relative to the largest one possible at this lightness
+		d := max + min
+		if d > 0xffff {
+			d = 0x1fffe - d
+		}
+		s = (c*0xffff + d>>1) / d
+	}
+	return hue(r, g, b, max, c), s, (max + min + 1) >> 1
+}
+
+func hslToRGB(h, s, l uint32) (uint32, uint32, uint32) {
+	d := l << 1
+	if d > 0xffff {
+		d = 0x1fffe - d
+	}
+	c := (d*s + 0x7fff) / 0xffff
+	max := l + (c+1)>>1
+	if max > 0xffff {
+		max = 0xffff
+	}
+	if c > max {
+		c = max
+	}
+	return hueToRGB(h, max, c)
+}
+
+// toHSX converts the color to HSL or HSV
+func toHSX(r, g, b uint32, hsl bool) [3]uint32 {
+	if hsl {
+		h, s, l := rgbToHSL(r, g, b)
+		return [3]uint32{h, s, l}
+	}
+	h, s, v := rgbToHSV(r, g, b)
+	return [3]uint32{h, s, v}
+}
+
+func fromHSX(v [3]uint32, hsl bool) (uint32, uint32, uint32) {
+	if hsl {
+		return hslToRGB(v[0], v[1], v[2])
+	}
+	return hsvToRGB(v[0], v[1], v[2])
+}
+
+func hsxName(hsl bool) string {
+	if hsl {
+		return "hsl"
+	}
+	return "hsv"
+}
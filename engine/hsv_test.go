@@ -0,0 +1,35 @@
+package engine
+
+import "testing"
+
+func TestHSV(t *testing.T) {
+	for _, tt := range []struct {
+		r, g, b uint32
+		h, s, v uint32
+	}{
+		{0xffff, 0, 0, 0, 0xffff, 0xffff},
+		{0, 0xffff, 0, 0x5555, 0xffff, 0xffff},
+		{0, 0, 0xffff, 0xaaab, 0xffff, 0xffff},
+		{0x8000, 0x8000, 0x8000, 0, 0, 0x8000},
+	} {
+		if h, s, v := rgbToHSV(tt.r, tt.g, tt.b); h != tt.h || s != tt.s || v != tt.v {
+			t.Errorf("%x,%x,%x: HSV %x,%x,%x, expected %x,%x,%x", tt.r, tt.g, tt.b, h, s, v, tt.h, tt.s, tt.v)
+		}
+	}
+
+	for _, hsl := range []bool{false, true} {
+		for r := uint32(0); r <= 0xffff; r += 0x1111 {
+			for g := uint32(0); g <= 0xffff; g += 0x0f0f {
+				for b := uint32(0); b <= 0xffff; b += 0x0707 {
+					// 16 bit hue is 1/10923 of a sector so the middle component may be off by a few units
+					r1, g1, b1 := fromHSX(toHSX(r, g, b, hsl), hsl)
+					for _, d := range []int{int(r1) - int(r), int(g1) - int(g), int(b1) - int(b)} {
+						if d < -4 || d > 4 {
+							t.Fatalf("%s: %x,%x,%x: round trip %x,%x,%x", hsxName(hsl), r, g, b, r1, g1, b1)
+						}
+					}
+				}
+			}
+		}
+	}
+}
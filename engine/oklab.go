@@ -0,0 +1,63 @@
+package engine
+
+// OKLab with 16 bit components. L is in 0..0xffff, a and b are centered at 0x8000 with the same scale as L.
+// The matrices come from https://bottosson.github.io/posts/oklab/. Every product is converted explicitly
+// to keep the results the same on all architectures
+
+type mat3 [3][3]float64
+
+var (
+	oklabM1 = mat3{
+		{0.4122214708, 0.5363325363, 0.0514459929},
+		{0.2119034982, 0.6806995451, 0.1073969566},
+		{0.0883024619, 0.2817188376, 0.6299787005},
+	}
+	oklabM2 = mat3{
+		{0.2104542553, 0.7936177850, -0.0040720468},
+		{1.9779984951, -2.4285922050, 0.4505937099},
+		{0.0259040371, 0.7827717662, -0.8086757660},
+	}
+	oklabM2Inv = mat3{
+		{1, 0.3963377774, 0.2158037573},
+		{1, -0.1055613458, -0.0638541728},
+		{1, -0.0894841775, -1.2914855480},
+	}
+	oklabM1Inv = mat3{
+		{4.0767416621, -3.3077115913, 0.2309699292},
+		{-1.2684380046, 2.6097574011, -0.3413193965},
+		{-0.0041960863, -0.7034186147, 1.7076147010},
+	}
+)
+
+func (m *mat3) mul(x, y, z float64) (float64, float64, float64) {
+	return float64(m[0][0]*x) + float64(m[0][1]*y) + float64(m[0][2]*z),
+		float64(m[1][0]*x) + float64(m[1][1]*y) + float64(m[1][2]*z),
+		float64(m[2][0]*x) + float64(m[2][1]*y) + float64(m[2][2]*z)
+}
+
+func to16(v float64) uint32 {
+	return clamp16(int64(float64(v*0xffff) + 0.5))
+}
+
+// rgbToOKLab converts sRGB or, if linear is set, linear light RGB to OKLab
+func rgbToOKLab(r, g, b uint32, linear bool) (uint32, uint32, uint32) {
+	var lr, lg, lb float64
+	if linear {
+		lr, lg, lb = float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff
+	} else {
+		lr, lg, lb = linearize(r), linearize(g), linearize(b)
+	}
+	l, m, s := oklabM1.mul(lr, lg, lb)
+	L, A, B := oklabM2.mul(cbrt(l), cbrt(m), cbrt(s))
+	return to16(L), to16(A + 0.5), to16(B + 0.5)
+}
+
+func oklabToRGB(L, A, B uint32, linear bool) (uint32, uint32, uint32) {
+	l, m, s := oklabM2Inv.mul(float64(L)/0xffff, float64(A)/0xffff-0.5, float64(B)/0xffff-0.5)
+	l, m, s = float64(float64(l*l)*l), float64(float64(m*m)*m), float64(float64(s*s)*s)
+	r, g, b := oklabM1Inv.mul(l, m, s)
+	if linear {
+		return to16(r), to16(g), to16(b)
+	}
+	return delinearize(r), delinearize(g), delinearize(b)
+}
@@ -0,0 +1,28 @@
+package engine
+
+import "testing"
+
+func TestOKLab(t *testing.T) {
+	// White and black are achromatic
+	if l, a, b := rgbToOKLab(0xffff, 0xffff, 0xffff, false); l != 0xffff || a != 0x8000 || b != 0x8000 {
+		t.Errorf("white: %x,%x,%x", l, a, b)
+	}
+	if l, a, b := rgbToOKLab(0, 0, 0, false); l != 0 || a != 0x8000 || b != 0x8000 {
+		t.Errorf("black: %x,%x,%x", l, a, b)
+	}
+
+	for r := uint32(0); r <= 0xffff; r += 0x1111 {
+		for g := uint32(0); g <= 0xffff; g += 0x0f0f {
+			for b := uint32(0); b <= 0xffff; b += 0x0707 {
+				// 16 bit Lab is coarse where the sRGB curve is steep near zero. 32 is still below 1/8 of an 8 bit step
+				l, a, bb := rgbToOKLab(r, g, b, false)
+				r1, g1, b1 := oklabToRGB(l, a, bb, false)
+				for _, d := range []int{int(r1) - int(r), int(g1) - int(g), int(b1) - int(b)} {
+					if d < -32 || d > 32 {
+						t.Fatalf("%x,%x,%x: round trip %x,%x,%x", r, g, b, r1, g1, b1)
+					}
+				}
+			}
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package engine
 
 import (
-	"image/color"
+	"fmt"
 	"sort"
 )
 
@@ -15,6 +15,47 @@ const (
 	OpMulYCC
 	OpXorRGB
 	OpXorYCC
+	OpScreen
+	OpOverlay
+	OpSoftLight
+	OpHardLight
+	OpDifference
+	OpExclusion
+	OpSubtract
+	OpDivide
+	OpDarken
+	OpLighten
+	OpDodge
+	OpBurn
+	OpAndRGB
+	OpAndYCC
+	OpAndRaw
+	OpOrRGB
+	OpOrYCC
+	OpOrRaw
+	OpNandRGB
+	OpNandYCC
+	OpNandRaw
+	OpSubRGBMod
+	OpSubYCCMod
+	OpSubRawMod
+	OpMulRGBMod
+	OpMulYCCMod
+	OpMulRawMod
+	OpRotRGB
+	OpRotYCC
+	OpRotRaw
+	OpShlRGB
+	OpShlYCC
+	OpShlRaw
+	OpAddHueMod
+	OpMulSat
+	OpXorVal
+	OpHue
+	OpSaturation
+	OpColor
+	OpLuminosity
+	OpAddLabMod
 	OpNumOps
 )
 
@@ -25,6 +66,25 @@ type Operation interface {
 	String() string
 }
 
+// SpanOperation is implemented by operations able to process a whole span at once.
+// ApplySpan is equivalent to calling Apply(dst[i], src[i]) for every pixel and storing the result to dst[i]
+type SpanOperation interface {
+	Operation
+	ApplySpan(dst, src []NRGBA)
+}
+
+// ApplySpan applies the operation to the span falling back to per pixel calls
+func ApplySpan(op Operation, dst, src []NRGBA) {
+	if so, ok := op.(SpanOperation); ok {
+		so.ApplySpan(dst, src)
+		return
+	}
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = op.Apply(dst[i], src[i])
+	}
+}
+
 type opCompose struct{}
 
 func (o opCompose) Apply(dst, src NRGBA) NRGBA {
@@ -53,12 +113,21 @@ func (o opCompose) Apply(dst, src NRGBA) NRGBA {
 	return NRGBA{r, g, b, a}
 }
 
+func (o opCompose) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
+
 func (o opCompose) String() string { return "comp" }
 
 type opReplace struct{}
 
 func (o opReplace) Apply(dst, src NRGBA) NRGBA { return src }
 
+func (o opReplace) ApplySpan(dst, src []NRGBA) { copy(dst, src) }
+
 func (o opReplace) String() string { return "rep" }
 
 type opAdd struct{}
@@ -77,31 +146,14 @@ func (o opAdd) Apply(dst, src NRGBA) NRGBA {
 		sb = 0xffff
 	}
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
-	}
-
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
-
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
-
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+func (o opAdd) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
-
-	return NRGBA{r, g, b, a}
 }
 
 func (o opAdd) String() string { return "add" }
@@ -117,75 +169,50 @@ func (o opAddRGBMod) Apply(dst, src NRGBA) NRGBA {
 	sg &= 0xffff
 	sb &= 0xffff
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
-	}
-
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
-
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
-
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+func (o opAddRGBMod) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
-
-	return NRGBA{r, g, b, a}
 }
 
 func (o opAddRGBMod) String() string { return "addrgbm" }
 
-type opAddYCCMod struct{}
+type opAddYCCMod struct {
+	legacy bool
+}
 
 func (o opAddYCCMod) Apply(dst, src NRGBA) NRGBA {
-	sY, sCb, sCr := color.RGBToYCbCr(uint8(src[0]>>8), uint8(src[1]>>8), uint8(src[2]>>8))
-	dY, dCb, dCr := color.RGBToYCbCr(uint8(dst[0]>>8), uint8(dst[1]>>8), uint8(dst[2]>>8))
-
-	sY = (sY + dY) & 0xff
-	sCb = uint8((int32(sCb) + int32(dCb) - 128) & 0xff)
-	sCr = uint8((int32(sCr) + int32(dCr) - 128) & 0xff)
-
-	r8, g8, b8 := color.YCbCrToRGB(sY, sCb, sCr)
-	sr := (uint32(r8) << 8) | uint32(r8)
-	sg := (uint32(g8) << 8) | uint32(g8)
-	sb := (uint32(b8) << 8) | uint32(b8)
-
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
+	var sr, sg, sb uint32
+	if o.legacy {
+		sY, sCb, sCr := rgbToYCC8(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC8(dst[0], dst[1], dst[2])
+
+		sY = (sY + dY) & 0xff
+		sCb = uint8((int32(sCb) + int32(dCb) - 128) & 0xff)
+		sCr = uint8((int32(sCr) + int32(dCr) - 128) & 0xff)
+		sr, sg, sb = ycc8ToRGB(sY, sCb, sCr)
+	} else {
+		sY, sCb, sCr := rgbToYCC(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC(dst[0], dst[1], dst[2])
+
+		sY = (sY + dY) & 0xffff
+		sCb = (sCb + dCb - 0x8000) & 0xffff
+		sCr = (sCr + dCr - 0x8000) & 0xffff
+		sr, sg, sb = yccToRGB(sY, sCb, sCr)
 	}
 
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
-
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
-
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+func (o opAddYCCMod) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
-
-	return NRGBA{r, g, b, a}
 }
 
 func (o opAddYCCMod) String() string { return "addyccm" }
@@ -206,169 +233,359 @@ func (o opMulRGB) Apply(dst, src NRGBA) NRGBA {
 		sb = 0xffff
 	}
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
-	}
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
+func (o opMulRGB) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
 
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
+func (o opMulRGB) String() string { return "mulrgb" }
 
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+type opMulYCC struct {
+	legacy bool
+}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+func (o opMulYCC) Apply(dst, src NRGBA) NRGBA {
+	var sr, sg, sb uint32
+	if o.legacy {
+		sY, sCb, sCr := rgbToYCC8(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC8(dst[0], dst[1], dst[2])
+
+		sY = uint8(uint32(sY) * uint32(dY) / 0xff)
+		sCb = uint8((int32(sCb)-128)*(int32(dCb)-128)/0xff + 128)
+		sCr = uint8((int32(sCr)-128)*(int32(dCr)-128)/0xff + 128)
+		sr, sg, sb = ycc8ToRGB(sY, sCb, sCr)
+	} else {
+		sY, sCb, sCr := rgbToYCC(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC(dst[0], dst[1], dst[2])
+
+		sY = sY * dY / 0xffff
+		sCb = uint32((int32(sCb)-0x8000)*(int32(dCb)-0x8000)/0xffff + 0x8000)
+		sCr = uint32((int32(sCr)-0x8000)*(int32(dCr)-0x8000)/0xffff + 0x8000)
+		sr, sg, sb = yccToRGB(sY, sCb, sCr)
 	}
 
-	return NRGBA{r, g, b, a}
+	return blendCompose(dst, src, sr, sg, sb)
 }
 
-func (o opMulRGB) String() string { return "mulrgb" }
+func (o opMulYCC) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
 
-type opMulYCC struct{}
+func (o opMulYCC) String() string { return "mulycc" }
 
-func (o opMulYCC) Apply(dst, src NRGBA) NRGBA {
-	sY, sCb, sCr := color.RGBToYCbCr(uint8(src[0]>>8), uint8(src[1]>>8), uint8(src[2]>>8))
-	dY, dCb, dCr := color.RGBToYCbCr(uint8(dst[0]>>8), uint8(dst[1]>>8), uint8(dst[2]>>8))
+type opXorRGB struct{}
 
-	sY = uint8(uint32(sY) * uint32(dY) / 0xff)
-	sCb = uint8((int32(sCb)-128)*(int32(dCb)-128)/0xff + 128)
-	sCr = uint8((int32(sCr)-128)*(int32(dCr)-128)/0xff + 128)
+func (o opXorRGB) Apply(dst, src NRGBA) NRGBA {
+	sr := src[0] ^ dst[0]
+	sg := src[1] ^ dst[1]
+	sb := src[2] ^ dst[2]
 
-	r8, g8, b8 := color.YCbCrToRGB(sY, sCb, sCr)
-	sr := (uint32(r8) << 8) | uint32(r8)
-	sg := (uint32(g8) << 8) | uint32(g8)
-	sb := (uint32(b8) << 8) | uint32(b8)
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
+func (o opXorRGB) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
+}
 
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
+func (o opXorRGB) String() string { return "xorrgb" }
 
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
+type opXorYCC struct {
+	legacy bool
+}
 
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+func (o opXorYCC) Apply(dst, src NRGBA) NRGBA {
+	var sr, sg, sb uint32
+	if o.legacy {
+		sY, sCb, sCr := rgbToYCC8(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC8(dst[0], dst[1], dst[2])
+
+		sY = sY ^ dY
+		sCb = uint8(((int32(sCb) - 128) ^ (int32(dCb) - 128)) + 128)
+		sCr = uint8(((int32(sCr) - 128) ^ (int32(dCr) - 128)) + 128)
+		sr, sg, sb = ycc8ToRGB(sY, sCb, sCr)
+	} else {
+		sY, sCb, sCr := rgbToYCC(src[0], src[1], src[2])
+		dY, dCb, dCr := rgbToYCC(dst[0], dst[1], dst[2])
+
+		sY = sY ^ dY
+		sCb = uint32(((int32(sCb)-0x8000)^(int32(dCb)-0x8000))+0x8000) & 0xffff
+		sCr = uint32(((int32(sCr)-0x8000)^(int32(dCr)-0x8000))+0x8000) & 0xffff
+		sr, sg, sb = yccToRGB(sY, sCb, sCr)
+	}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opXorYCC) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
+}
 
-	return NRGBA{r, g, b, a}
+func (o opXorYCC) String() string { return "xorycc" }
+
+// The HSV operations change a single component of the destination
+
+type opAddHueMod struct{}
+
+func (o opAddHueMod) Apply(dst, src NRGBA) NRGBA {
+	sh, _, _ := rgbToHSV(src[0], src[1], src[2])
+	dh, ds, dv := rgbToHSV(dst[0], dst[1], dst[2])
+	sr, sg, sb := hsvToRGB((sh+dh)&0xffff, ds, dv)
+	return blendCompose(dst, src, sr, sg, sb)
 }
 
-func (o opMulYCC) String() string { return "mulycc" }
+func (o opAddHueMod) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
 
-type opXorRGB struct{}
+func (o opAddHueMod) String() string { return "addhuem" }
 
-func (o opXorRGB) Apply(dst, src NRGBA) NRGBA {
-	sr := src[0] ^ dst[0]
-	sg := src[1] ^ dst[1]
-	sb := src[2] ^ dst[2]
+type opMulSat struct{}
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
+func (o opMulSat) Apply(dst, src NRGBA) NRGBA {
+	_, ss, _ := rgbToHSV(src[0], src[1], src[2])
+	dh, ds, dv := rgbToHSV(dst[0], dst[1], dst[2])
+	sr, sg, sb := hsvToRGB(dh, ss*ds/0xffff, dv)
+	return blendCompose(dst, src, sr, sg, sb)
+}
+
+func (o opMulSat) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
+}
 
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
+func (o opMulSat) String() string { return "mulsat" }
 
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
+type opXorVal struct{}
 
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+func (o opXorVal) Apply(dst, src NRGBA) NRGBA {
+	_, _, sv := rgbToHSV(src[0], src[1], src[2])
+	dh, ds, dv := rgbToHSV(dst[0], dst[1], dst[2])
+	sr, sg, sb := hsvToRGB(dh, ds, sv^dv)
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+func (o opXorVal) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
 	}
+}
 
-	return NRGBA{r, g, b, a}
+func (o opXorVal) String() string { return "xorval" }
+
+type opAddLabMod struct {
+	linear bool
 }
 
-func (o opXorRGB) String() string { return "xorrgb" }
+func (o opAddLabMod) Apply(dst, src NRGBA) NRGBA {
+	sL, sA, sB := rgbToOKLab(src[0], src[1], src[2], o.linear)
+	dL, dA, dB := rgbToOKLab(dst[0], dst[1], dst[2], o.linear)
+	sr, sg, sb := oklabToRGB((sL+dL)&0xffff, (sA+dA-0x8000)&0xffff, (sB+dB-0x8000)&0xffff, o.linear)
+	return blendCompose(dst, src, sr, sg, sb)
+}
 
-type opXorYCC struct{}
+func (o opAddLabMod) ApplySpan(dst, src []NRGBA) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] = o.Apply(dst[i], src[i])
+	}
+}
 
-func (o opXorYCC) Apply(dst, src NRGBA) NRGBA {
-	sY, sCb, sCr := color.RGBToYCbCr(uint8(src[0]>>8), uint8(src[1]>>8), uint8(src[2]>>8))
-	dY, dCb, dCr := color.RGBToYCbCr(uint8(dst[0]>>8), uint8(dst[1]>>8), uint8(dst[2]>>8))
+func (o opAddLabMod) String() string { return "addlabm" }
 
-	sY = sY ^ dY
-	sCb = uint8(((int32(sCb) - 128) ^ (int32(dCb) - 128)) + 128)
-	sCr = uint8(((int32(sCr) - 128) ^ (int32(dCr) - 128)) + 128)
+var opsTable = []Operation{
+	OpCompose:    opCompose{},
+	OpReplace:    opReplace{},
+	OpAdd:        opAdd{},
+	OpAddRGBMod:  opAddRGBMod{},
+	OpAddYCCMod:  opAddYCCMod{},
+	OpMulRGB:     opMulRGB{},
+	OpMulYCC:     opMulYCC{},
+	OpXorRGB:     opXorRGB{},
+	OpXorYCC:     opXorYCC{},
+	OpScreen:     opScreen{},
+	OpOverlay:    opOverlay{},
+	OpSoftLight:  opSoftLight{},
+	OpHardLight:  opHardLight{},
+	OpDifference: opDifference{},
+	OpExclusion:  opExclusion{},
+	OpSubtract:   opSubtract{},
+	OpDivide:     opDivide{},
+	OpDarken:     opDarken{},
+	OpLighten:    opLighten{},
+	OpDodge:      opDodge{},
+	OpBurn:       opBurn{},
+	OpAndRGB:     opBitRGB{bitAnd},
+	OpAndYCC:     opBitYCC{bitAnd},
+	OpAndRaw:     opBitRaw{bitAnd},
+	OpOrRGB:      opBitRGB{bitOr},
+	OpOrYCC:      opBitYCC{bitOr},
+	OpOrRaw:      opBitRaw{bitOr},
+	OpNandRGB:    opBitRGB{bitNand},
+	OpNandYCC:    opBitYCC{bitNand},
+	OpNandRaw:    opBitRaw{bitNand},
+	OpSubRGBMod:  opBitRGB{bitSub},
+	OpSubYCCMod:  opBitYCC{bitSub},
+	OpSubRawMod:  opBitRaw{bitSub},
+	OpMulRGBMod:  opBitRGB{bitMul},
+	OpMulYCCMod:  opBitYCC{bitMul},
+	OpMulRawMod:  opBitRaw{bitMul},
+	OpRotRGB:     opBitRGB{bitRot},
+	OpRotYCC:     opBitYCC{bitRot},
+	OpRotRaw:     opBitRaw{bitRot},
+	OpShlRGB:     opBitRGB{bitShl},
+	OpShlYCC:     opBitYCC{bitShl},
+	OpShlRaw:     opBitRaw{bitShl},
+	OpAddHueMod:  opAddHueMod{},
+	OpMulSat:     opMulSat{},
+	OpXorVal:     opXorVal{},
+	OpHue:        opHue{},
+	OpSaturation: opSaturation{},
+	OpColor:      opColor{},
+	OpLuminosity: opLuminosity{},
+	OpAddLabMod:  opAddLabMod{},
+}
 
-	r8, g8, b8 := color.YCbCrToRGB(sY, sCb, sCr)
-	sr := (uint32(r8) << 8) | uint32(r8)
-	sg := (uint32(g8) << 8) | uint32(g8)
-	sb := (uint32(b8) << 8) | uint32(b8)
+// legacyYCC returns the 8 bit variant of the YCbCr operations
+func legacyYCC(op Operation) Operation {
+	switch op.(type) {
+	case opAddYCCMod:
+		return opAddYCCMod{legacy: true}
+	case opMulYCC:
+		return opMulYCC{legacy: true}
+	case opXorYCC:
+		return opXorYCC{legacy: true}
+	}
+	return op
+}
 
-	if src[3] == 0xffff {
-		return NRGBA{sr, sg, sb, src[3]}
+// opOpacity mixes the result of the operation with the destination
+type opOpacity struct {
+	op Operation
+	a  uint32
+}
+
+// withOpacity returns the operation mixed with the destination according to the opacity in 0..1 range
+func withOpacity(op Operation, opacity float64) Operation {
+	a := uint32(float64(opacity*0xffff) + 0.5)
+	if a >= 0xffff {
+		return op
 	}
+	return opOpacity{op: op, a: a}
+}
 
-	// Compose
-	sr = (sr * src[3]) / 0xffff
-	sg = (sg * src[3]) / 0xffff
-	sb = (sb * src[3]) / 0xffff
+func lerp16(x, y, a uint32) uint32 {
+	return (x*(0xffff-a) + y*a) / 0xffff
+}
 
-	dr := (dst[0] * dst[3]) / 0xffff
-	dg := (dst[1] * dst[3]) / 0xffff
-	db := (dst[2] * dst[3]) / 0xffff
+func (o opOpacity) Apply(dst, src NRGBA) NRGBA {
+	return o.mix(dst, o.op.Apply(dst, src))
+}
 
-	r := sr + dr*(0xffff-src[3])/0xffff
-	g := sg + dg*(0xffff-src[3])/0xffff
-	b := sb + db*(0xffff-src[3])/0xffff
-	a := src[3] + dst[3]*(0xffff-src[3])/0xffff
+func (o opOpacity) mix(dst, res NRGBA) NRGBA {
+	if dst[3] == 0xffff && res[3] == 0xffff {
+		return NRGBA{lerp16(dst[0], res[0], o.a), lerp16(dst[1], res[1], o.a), lerp16(dst[2], res[2], o.a), 0xffff}
+	}
 
-	if a != 0 {
-		r = (r * 0xffff) / a
-		g = (g * 0xffff) / a
-		b = (b * 0xffff) / a
+	// Mix premultiplied values
+	a := lerp16(dst[3], res[3], o.a)
+	if a == 0 {
+		return NRGBA{}
 	}
+	r := lerp16(dst[0]*dst[3]/0xffff, res[0]*res[3]/0xffff, o.a)
+	g := lerp16(dst[1]*dst[3]/0xffff, res[1]*res[3]/0xffff, o.a)
+	b := lerp16(dst[2]*dst[3]/0xffff, res[2]*res[3]/0xffff, o.a)
+	return NRGBA{r * 0xffff / a, g * 0xffff / a, b * 0xffff / a, a}
+}
 
-	return NRGBA{r, g, b, a}
+func (o opOpacity) ApplySpan(dst, src []NRGBA) {
+	var tmp [spanLen]NRGBA
+	src = src[:len(dst)]
+	for len(dst) != 0 {
+		n := len(dst)
+		if n > spanLen {
+			n = spanLen
+		}
+		res := tmp[:n]
+		copy(res, dst[:n])
+		ApplySpan(o.op, res, src[:n])
+		for i, c := range res {
+			dst[i] = o.mix(dst[i], c)
+		}
+		dst, src = dst[n:], src[n:]
+	}
 }
 
-func (o opXorYCC) String() string { return "xorycc" }
+func (o opOpacity) String() string {
+	return fmt.Sprintf("%v:%.2f", o.op, float64(o.a)/0xffff)
+}
 
-var opsTable = []Operation{
-	OpCompose:   opCompose{},
-	OpReplace:   opReplace{},
-	OpAdd:       opAdd{},
-	OpAddRGBMod: opAddRGBMod{},
-	OpAddYCCMod: opAddYCCMod{},
-	OpMulRGB:    opMulRGB{},
-	OpMulYCC:    opMulYCC{},
-	OpXorRGB:    opXorRGB{},
-	OpXorYCC:    opXorYCC{},
+// opSRGB runs an operation defined on sRGB values over linear light pixels
+type opSRGB struct {
+	op Operation
+}
+
+func (o opSRGB) Apply(dst, src NRGBA) NRGBA {
+	toLinear, toSRGB := linearTables()
+	s := [3]NRGBA{dst, src}
+	mapSpan(s[:2], toSRGB)
+	s[2] = o.op.Apply(s[0], s[1])
+	mapSpan(s[2:], toLinear)
+	return s[2]
+}
+
+func (o opSRGB) ApplySpan(dst, src []NRGBA) {
+	toLinear, toSRGB := linearTables()
+	var tmp [spanLen]NRGBA
+	src = src[:len(dst)]
+	for len(dst) != 0 {
+		n := len(dst)
+		if n > spanLen {
+			n = spanLen
+		}
+		s := tmp[:n]
+		copy(s, src[:n])
+		mapSpan(s, toSRGB)
+		mapSpan(dst[:n], toSRGB)
+		ApplySpan(o.op, dst[:n], s)
+		mapSpan(dst[:n], toLinear)
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+func (o opSRGB) String() string { return o.op.String() }
+
+// linearLight returns the variant of the operation expecting linear light input. Compositing and
+// the blend modes mix light so they stay in linear light, the Lab operations convert from it.
+// The YCbCr, HSV, HSL, modular and bitwise operations run in sRGB
+func linearLight(op Operation) Operation {
+	switch op.(type) {
+	case opAddLabMod:
+		return opAddLabMod{linear: true}
+	case opCompose, opReplace, opAdd, opMulRGB, opScreen, opOverlay, opSoftLight, opHardLight,
+		opDifference, opExclusion, opSubtract, opDivide, opDarken, opLighten, opDodge, opBurn:
+		return op
+	}
+	return opSRGB{op}
 }
 
 func GetOp(op int) Operation {
@@ -379,15 +596,56 @@ func GetOp(op int) Operation {
 }
 
 var opsNamesTable = map[string]Operation{
-	"cmp":     opCompose{},
-	"src":     opReplace{},
-	"add":     opAdd{},
-	"addrgbm": opAddRGBMod{},
-	"addyccm": opAddYCCMod{},
-	"mulrgb":  opMulRGB{},
-	"mulycc":  opMulYCC{},
-	"xorrgb":  opXorRGB{},
-	"xorycc":  opXorYCC{},
+	"cmp":        opCompose{},
+	"src":        opReplace{},
+	"add":        opAdd{},
+	"addrgbm":    opAddRGBMod{},
+	"addyccm":    opAddYCCMod{},
+	"mulrgb":     opMulRGB{},
+	"mulycc":     opMulYCC{},
+	"xorrgb":     opXorRGB{},
+	"xorycc":     opXorYCC{},
+	"screen":     opScreen{},
+	"overlay":    opOverlay{},
+	"softlight":  opSoftLight{},
+	"hardlight":  opHardLight{},
+	"diff":       opDifference{},
+	"excl":       opExclusion{},
+	"sub":        opSubtract{},
+	"div":        opDivide{},
+	"darken":     opDarken{},
+	"lighten":    opLighten{},
+	"dodge":      opDodge{},
+	"burn":       opBurn{},
+	"andrgb":     opBitRGB{bitAnd},
+	"andycc":     opBitYCC{bitAnd},
+	"andraw":     opBitRaw{bitAnd},
+	"orrgb":      opBitRGB{bitOr},
+	"orycc":      opBitYCC{bitOr},
+	"orraw":      opBitRaw{bitOr},
+	"nandrgb":    opBitRGB{bitNand},
+	"nandycc":    opBitYCC{bitNand},
+	"nandraw":    opBitRaw{bitNand},
+	"subrgbm":    opBitRGB{bitSub},
+	"subyccm":    opBitYCC{bitSub},
+	"subrawm":    opBitRaw{bitSub},
+	"mulrgbm":    opBitRGB{bitMul},
+	"mulyccm":    opBitYCC{bitMul},
+	"mulrawm":    opBitRaw{bitMul},
+	"rotrgb":     opBitRGB{bitRot},
+	"rotycc":     opBitYCC{bitRot},
+	"rotraw":     opBitRaw{bitRot},
+	"shlrgb":     opBitRGB{bitShl},
+	"shlycc":     opBitYCC{bitShl},
+	"shlraw":     opBitRaw{bitShl},
+	"addhuem":    opAddHueMod{},
+	"mulsat":     opMulSat{},
+	"xorval":     opXorVal{},
+	"hue":        opHue{},
+	"saturation": opSaturation{},
+	"color":      opColor{},
+	"luminosity": opLuminosity{},
+	"addlabm":    opAddLabMod{},
 }
 
 func GetOpID(op string) Operation {
@@ -0,0 +1,201 @@
+package engine
+
+import "testing"
+
+// pixelOp hides ApplySpan to force the per pixel fallback
+type pixelOp struct {
+	Operation
+}
+
+// BenchmarkOps times the operations alone over preloaded spans. The pixel mode calls Apply through
+// the interface for every pixel like the fallback of ApplySpan does
+func BenchmarkOps(b *testing.B) {
+	_, src := benchImages(8)
+	s := make([]NRGBA, 4096)
+	d0 := make([]NRGBA, len(s))
+	d := make([]NRGBA, len(s))
+	loadSpan(s, src.Pix)
+	loadSpan(d0, src.Pix[len(src.Pix)/2:])
+	for _, name := range OpNames() {
+		for _, mode := range []string{"span", "pixel"} {
+			op := GetOpID(name)
+			if mode == "pixel" {
+				op = pixelOp{op}
+			}
+			b.Run(name+"/"+mode, func(b *testing.B) {
+				b.SetBytes(int64(len(d) * 8))
+				for i := 0; i < b.N; i++ {
+					copy(d, d0)
+					ApplySpan(op, d, s)
+				}
+			})
+		}
+	}
+}
+
+func TestApplySpan(t *testing.T) {
+	dst, src := benchImages(8)
+	s := make([]NRGBA, 256)
+	d0 := make([]NRGBA, 256)
+	d1 := make([]NRGBA, 256)
+	loadSpan(s, src.Pix[100*src.Stride:])
+	for i := range s {
+		s[i][3] = uint32(i * 0x101)
+	}
+	for _, name := range OpNames() {
+		for _, op := range []Operation{GetOpID(name), linearLight(GetOpID(name))} {
+			if _, ok := op.(SpanOperation); !ok {
+				t.Errorf("%s: no span kernel", name)
+			}
+			loadSpan(d0, dst.Pix)
+			copy(d1, d0)
+			ApplySpan(op, d0, s)
+			ApplySpan(pixelOp{op}, d1, s)
+			for i := range d0 {
+				if d0[i] != d1[i] {
+					t.Fatalf("%s: span result %v differs from per pixel result %v", name, d0[i], d1[i])
+				}
+			}
+		}
+	}
+}
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  uint32
+		src  uint32
+		out  uint32
+	}{
+		{"screen", 0x8000, 0, 0x8000},
+		{"screen", 0x8000, 0xffff, 0xffff},
+		{"overlay", 0, 0x8000, 0},
+		{"overlay", 0xffff, 0x8000, 0xffff},
+		{"softlight", 0x4000, 0, 0x1000},
+		{"softlight", 0x4000, 0xffff, 0x6ffe},
+		{"hardlight", 0x8000, 0, 0},
+		{"hardlight", 0x8000, 0xffff, 0xffff},
+		{"diff", 0x1000, 0x3000, 0x2000},
+		{"excl", 0x8000, 0xffff, 0x7fff},
+		{"sub", 0x1000, 0x3000, 0},
+		{"div", 0x4000, 0x8000, 0x7fff},
+		{"div", 0, 0, 0},
+		{"darken", 0x1000, 0x3000, 0x1000},
+		{"lighten", 0x1000, 0x3000, 0x3000},
+		{"dodge", 0x4000, 0x8000, 0x8000},
+		{"dodge", 0, 0xffff, 0},
+		{"burn", 0xc000, 0x8000, 0x8002},
+		{"burn", 0xffff, 0, 0xffff},
+	}
+	for _, tt := range tests {
+		dst := NRGBA{tt.dst, tt.dst, tt.dst, 0xffff}
+		src := NRGBA{tt.src, tt.src, tt.src, 0xffff}
+		if res := GetOpID(tt.name).Apply(dst, src); res != (NRGBA{tt.out, tt.out, tt.out, 0xffff}) {
+			t.Errorf("%s(%#x, %#x) = %#x, expected %#x", tt.name, tt.dst, tt.src, res[0], tt.out)
+		}
+	}
+}
+
+func TestOpacity(t *testing.T) {
+	op := withOpacity(GetOp(OpReplace), 0.25)
+	if res := op.Apply(NRGBA{0, 0x8000, 0xffff, 0xffff}, NRGBA{0xffff, 0xffff, 0xffff, 0xffff}); res != (NRGBA{0x4000, 0x9fff, 0xffff, 0xffff}) {
+		t.Errorf("unexpected result %#x", res)
+	}
+	if res := op.Apply(NRGBA{0, 0, 0, 0}, NRGBA{0xffff, 0xffff, 0xffff, 0xffff}); res != (NRGBA{0xffff, 0xffff, 0xffff, 0x4000}) {
+		t.Errorf("unexpected result %#x", res)
+	}
+	if op := withOpacity(GetOp(OpXorRGB), 1); op != GetOp(OpXorRGB) {
+		t.Errorf("full opacity must return the operation itself, got %v", op)
+	}
+
+	dst, src := benchImages(8)
+	s := make([]NRGBA, 300)
+	d0 := make([]NRGBA, 300)
+	d1 := make([]NRGBA, 300)
+	loadSpan(s, src.Pix)
+	loadSpan(d0, dst.Pix)
+	for i := range s {
+		s[i][3] = uint32(i * 0xff)
+		d0[i][3] = 0xffff - uint32(i*0xff)
+	}
+	copy(d1, d0)
+	for _, name := range OpNames() {
+		op := withOpacity(GetOpID(name), 0.3)
+		ApplySpan(op, d0, s)
+		ApplySpan(pixelOp{op}, d1, s)
+		for i := range d0 {
+			if d0[i] != d1[i] {
+				t.Fatalf("%v: span result %v differs from per pixel result %v", op, d0[i], d1[i])
+			}
+		}
+	}
+}
+
+func TestBitOps(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  uint32
+		src  uint32
+		out  uint32
+	}{
+		{"andrgb", 0xff00, 0x0ff0, 0x0f00},
+		{"orrgb", 0xff00, 0x0ff0, 0xfff0},
+		{"nandrgb", 0xff00, 0x0ff0, 0xf0ff},
+		{"subrgbm", 0x1000, 0x3000, 0xe000},
+		{"mulrgbm", 0x0101, 0x0201, 0x0301},
+		{"rotrgb", 0x4000, 0x8001, 0x0018},
+		{"shlrgb", 0x0101, 0x3000, 0x0808},
+		{"andraw", 0x00ff, 0x0ff0, 0xf000},
+		{"subrawm", 0x0010, 0x0030, 0xe000},
+	}
+	for _, tt := range tests {
+		dst := NRGBA{tt.dst, tt.dst, tt.dst, 0xffff}
+		src := NRGBA{tt.src, tt.src, tt.src, 0xffff}
+		if res := GetOpID(tt.name).Apply(dst, src); res != (NRGBA{tt.out, tt.out, tt.out, 0xffff}) {
+			t.Errorf("%s(%#x, %#x) = %#x, expected %#x", tt.name, tt.dst, tt.src, res[0], tt.out)
+		}
+	}
+
+	// Gray stays gray in YCbCr
+	if res := GetOpID("orycc").Apply(NRGBA{0x1000, 0x1000, 0x1000, 0xffff}, NRGBA{0x0100, 0x0100, 0x0100, 0xffff}); res != (NRGBA{0x1100, 0x1100, 0x1100, 0xffff}) {
+		t.Errorf("orycc: unexpected result %#x", res)
+	}
+}
+
+func TestHSLBlend(t *testing.T) {
+	red := NRGBA{0xffff, 0, 0, 0xffff}
+	gray := NRGBA{0x8000, 0x8000, 0x8000, 0xffff}
+	tests := []struct {
+		name     string
+		dst, src NRGBA
+		out      NRGBA
+	}{
+		// Luminosity of the source keeps the destination gray
+		{"luminosity", gray, red, NRGBA{0x4ccd, 0x4ccd, 0x4ccd, 0xffff}},
+		// Gray source has no hue or saturation to give
+		{"saturation", red, gray, NRGBA{0x4ccd, 0x4ccd, 0x4ccd, 0xffff}},
+		{"color", red, gray, NRGBA{0x4ccd, 0x4ccd, 0x4ccd, 0xffff}},
+		{"hue", gray, red, gray},
+		{"addhuem", red, NRGBA{0, 0xffff, 0xffff, 0xffff}, NRGBA{0, 0xffff, 0xffff, 0xffff}},
+		{"mulsat", red, gray, NRGBA{0xffff, 0xffff, 0xffff, 0xffff}},
+		{"xorval", gray, gray, NRGBA{0, 0, 0, 0xffff}},
+	}
+	for _, tt := range tests {
+		if res := GetOpID(tt.name).Apply(tt.dst, tt.src); res != tt.out {
+			t.Errorf("%s(%#x, %#x) = %#x, expected %#x", tt.name, tt.dst, tt.src, res, tt.out)
+		}
+	}
+}
+
+func TestLabBlend(t *testing.T) {
+	// Gray of L 0.5 plus red of L 0.628 wraps L to 0.128 and keeps the red chroma which clips to dark red
+	gray := NRGBA{0x6379, 0x6379, 0x6379, 0xffff}
+	red := NRGBA{0xffff, 0, 0, 0xffff}
+	if res := GetOpID("addlabm").Apply(gray, red); !near(res, NRGBA{0x436f, 0, 0, 0xffff}) {
+		t.Errorf("addlabm(%#x, %#x) = %#x", gray, red, res)
+	}
+	// Black adds nothing
+	if res := GetOpID("addlabm").Apply(red, NRGBA{0, 0, 0, 0xffff}); !near(res, red) {
+		t.Errorf("addlabm(%#x, black) = %#x", red, res)
+	}
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Pixel sorting. Runs of pixels with the luminance inside the threshold interval are sorted along rows or columns.
+// A run ends at the block edge or, in the segment mode, at the edge of the segment so it may cross the blocks
+
+const (
+	sortLuma = iota
+	sortHue
+	sortSat
+	sortRed
+	sortGreen
+	sortBlue
+	sortAlpha
+	sortNumKeys
+)
+
+var sortKeyNames = [...]string{
+	sortLuma:  "l",
+	sortHue:   "h",
+	sortSat:   "s",
+	sortRed:   "r",
+	sortGreen: "g",
+	sortBlue:  "b",
+	sortAlpha: "a",
+}
+
+type filterSort struct {
+	key      uint8
+	lo, hi   uint8 // Luminance thresholds, inclusive
+	vertical bool
+	desc     bool
+	seg      bool // Sort the block rows or columns of the segment at once
+}
+
+// sortPixel is keyed by the run index in the upper half so a single stable sort of the line leaves
+// the runs and the pixels between them in place
+type sortPixel struct {
+	k uint64
+	c NRGBA
+}
+
+type sortLine []sortPixel
+
+func (l sortLine) Len() int           { return len(l) }
+func (l sortLine) Less(i, j int) bool { return l[i].k < l[j].k }
+func (l sortLine) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+var sortPool = sync.Pool{
+	New: func() interface{} { return new(sortLine) },
+}
+
+func lumaNRGBA(c NRGBA) uint32 {
+	return (19595*c[0] + 38470*c[1] + 7471*c[2] + 1<<15) >> 16
+}
+
+func (f filterSort) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	f.filterBlock(bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterSort) within(c NRGBA) bool {
+	l := lumaNRGBA(c) >> 8
+	return l >= uint32(f.lo) && l <= uint32(f.hi)
+}
+
+func (f filterSort) keyOf(c NRGBA) uint32 {
+	var k uint32
+	switch f.key {
+	case sortLuma:
+		k = lumaNRGBA(c)
+	case sortHue:
+		k, _, _ = rgbToHSV(c[0], c[1], c[2])
+	case sortSat:
+		_, k, _ = rgbToHSV(c[0], c[1], c[2])
+	default:
+		k = c[f.key-sortRed]
+	}
+	if f.desc {
+		k = ^k
+	}
+	return k
+}
+
+// sort sorts the runs of the line. The line is passed by pointer so sort.Stable doesn't allocate for the interface
+func (f filterSort) sort(lp *sortLine) {
+	line := *lp
+	var run uint64
+	inside := false
+	for i, p := range line {
+		in := f.within(p.c)
+		if !in || !inside {
+			run++
+		}
+		inside = in
+		line[i].k = run << 32
+		if in {
+			line[i].k |= uint64(f.keyOf(p.c))
+		}
+	}
+	sort.Stable(lp)
+}
+
+func (f filterSort) filterBlock(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	applyAreaFilter(f.sortArea, dst, dr, src, sp, op, dr.Dx()*dr.Dy())
+}
+
+// sortArea sorts the rows or the columns of the w*h area
+func (f filterSort) sortArea(pix []NRGBA, w, h int) {
+	lines, ln, step, stride := h, w, 1, w
+	if f.vertical {
+		lines, ln, step, stride = w, h, w, 1
+	}
+	lp := sortPool.Get().(*sortLine)
+	defer sortPool.Put(lp)
+	if cap(*lp) < ln {
+		*lp = make(sortLine, ln)
+	}
+	*lp = (*lp)[:ln]
+	line := *lp
+	for i := 0; i < lines; i++ {
+		p := pix[i*stride:]
+		for j := range line {
+			line[j].c = p[j*step]
+		}
+		f.sort(lp)
+		for j, v := range line {
+			p[j*step] = v.c
+		}
+	}
+}
+
+func (f filterSort) segment() (bool, bool) {
+	return f.seg, f.vertical
+}
+
+func (f filterSort) filterBand(dst *buffer, dr image.Rectangle, src *buffer, seg image.Rectangle, op Operation) {
+	applyAreaFilter(f.sortArea, dst, dr, src, dr.Min, op, bandReserve(dr, src.Rect, f.vertical))
+}
+
+func (f filterSort) String() string {
+	return fmt.Sprintf("sort:{k:%s,t:%d-%d,v:%t,d:%t,s:%t}", sortKeyNames[f.key], f.lo, f.hi, f.vertical, f.desc, f.seg)
+}
+
+func newFilterSort(opt *FilterOptions) Filter {
+	f := filterSort{
+		key:      uint8(rand.Intn(sortNumKeys)),
+		lo:       uint8(rand.Intn(128)),
+		hi:       uint8(128 + rand.Intn(128)),
+		vertical: rand.Intn(2) == 1,
+		desc:     rand.Intn(2) == 1,
+		seg:      rand.Intn(2) == 1,
+	}
+	return f
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	r := image.Rect(0, 0, 32, 4)
+	src := new(buffer).reuse(r, 8)
+	rng := rand.New(rand.NewSource(1))
+	row := make([]NRGBA, r.Dx())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := range row {
+			v := uint32(rng.Intn(0x10000))
+			row[x] = NRGBA{v, v, v, 0xffff}
+		}
+		src.store(row, 0, y)
+	}
+
+	for _, f := range []filterSort{
+		{key: sortRed, hi: 255},
+		{key: sortRed, hi: 255, desc: true},
+		{key: sortLuma, lo: 64, hi: 192},
+		{key: sortLuma, lo: 64, hi: 192, vertical: true, desc: true},
+	} {
+		// The whole image at once
+		ref := new(buffer).reuse(r, 8)
+		f.filterBlock(ref, r, src, image.Point{}, opReplace{})
+		// 8x2 blocks
+		dst := new(buffer).reuse(r, 8)
+		for y := r.Min.Y; y < r.Max.Y; y += 2 {
+			for x := r.Min.X; x < r.Max.X; x += 8 {
+				dr := image.Rect(x, y, x+8, y+2)
+				f.filterBlock(dst, dr, src, dr.Min, opReplace{})
+			}
+		}
+
+		lines, ln, block := r.Dy(), r.Dx(), 8
+		if f.vertical {
+			lines, ln, block = ln, lines, 2
+		}
+		for i := 0; i < lines; i++ {
+			var s, d, b [32]NRGBA
+			for j := 0; j < ln; j++ {
+				x, y := j, i
+				if f.vertical {
+					x, y = i, j
+				}
+				src.load(s[j:j+1], x, y)
+				ref.load(d[j:j+1], x, y)
+				dst.load(b[j:j+1], x, y)
+			}
+			// Runs of the whole line are sorted in place, the runs of the blocks end at the block edges
+			for j := 0; j < ln; j++ {
+				if !f.within(s[j]) && (d[j] != s[j] || b[j] != s[j]) {
+					t.Fatalf("%v: pixel %d of line %d is moved", f, j, i)
+				}
+				if j == 0 || !f.within(s[j-1]) || !f.within(s[j]) {
+					continue
+				}
+				if f.keyOf(d[j-1]) > f.keyOf(d[j]) {
+					t.Fatalf("%v: pixels %d and %d of line %d are not sorted", f, j-1, j, i)
+				}
+				if j%block != 0 && f.keyOf(b[j-1]) > f.keyOf(b[j]) {
+					t.Fatalf("%v: pixels %d and %d of line %d are not sorted in the block", f, j-1, j, i)
+				}
+			}
+		}
+	}
+}
+
+func TestSegmentBands(t *testing.T) {
+	r := image.Rect(0, 0, 32, 4)
+	// 16x2 blocks of 2 pixels, the segment covers the end of the first block row and the start of the second one
+	p := pass{bounds: r, blockSize: 2, blocksX: 16, blocks: 32, segStart: 5, segBlocks: 20}
+
+	bands, seg := p.segmentBands(false, nil)
+	if exp := []band{
+		{r: image.Rect(10, 0, 32, 2), b: 5, n: 11, step: 1},
+		{r: image.Rect(0, 2, 18, 4), b: 16, n: 9, step: 1},
+	}; len(bands) != len(exp) || bands[0] != exp[0] || bands[1] != exp[1] {
+		t.Errorf("rows: %v, expected %v", bands, exp)
+	}
+	if seg != r {
+		t.Errorf("segment: %v", seg)
+	}
+
+	bands, _ = p.segmentBands(true, nil)
+	if len(bands) != 16 {
+		t.Fatalf("%d columns", len(bands))
+	}
+	for c, bd := range bands {
+		exp := band{r: image.Rect(c*2, 0, c*2+2, 4), b: c, n: 2, step: 16}
+		if c < 5 {
+			exp = band{r: image.Rect(c*2, 2, c*2+2, 4), b: 16 + c, n: 1, step: 16}
+		} else if c > 8 {
+			exp.r.Max.Y, exp.n = 2, 1
+		}
+		if bd != exp {
+			t.Errorf("column %d: %v, expected %v", c, bd, exp)
+		}
+	}
+
+	// The runs cross the blocks. The first pass gathers the source shifted by a block row
+	src := new(buffer).reuse(r, 8)
+	row := make([]NRGBA, r.Dx())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := range row {
+			row[x] = NRGBA{uint32(0xffff - x*0x800 - y*0x100), 0, 0, 0xffff}
+		}
+		src.store(row, 0, y)
+	}
+	p.filter, p.op, p.first, p.shift, p.chunk = filterSort{key: sortRed, hi: 255}, opReplace{}, true, 16, 1
+	p.src, p.dst = src, new(buffer).reuse(r, 8)
+	var e Engine
+	e.runSegment(&p, false, new(buffer).reuse(r, 8))
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p.dst.load(row, 0, y)
+		x0, x1 := 10, 32
+		if y >= 2 {
+			x0, x1 = 0, 18
+		}
+		for x, c := range row {
+			var exp NRGBA
+			if x >= x0 && x < x1 {
+				// The band is reversed
+				exp = NRGBA{uint32(0xffff - (x0+x1-1-x)*0x800 - (y+2)%4*0x100), 0, 0, 0xffff}
+			}
+			if c != exp {
+				t.Fatalf("(%d, %d): %x, expected %x", x, y, c, exp)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	PlacementUniform = iota
+	PlacementGaussian
+	PlacementTop
+	PlacementBottom
+	PlacementCluster
+	PlacementFollow
+	PlacementNumModes
+)
+
+var placementNames = map[string]int{
+	"uniform":  PlacementUniform,
+	"gaussian": PlacementGaussian,
+	"top":      PlacementTop,
+	"bottom":   PlacementBottom,
+	"cluster":  PlacementCluster,
+	"follow":   PlacementFollow,
+}
+
+func GetPlacementID(name string) int {
+	if id, ok := placementNames[name]; ok {
+		return id
+	}
+	return -1
+}
+
+func PlacementNames() []string {
+	ret := make([]string, 0, len(placementNames))
+	for name := range placementNames {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// placer picks segment positions. All positions are relative to the image size
+type placer struct {
+	mode     int
+	center   float64
+	spread   float64
+	clusters []float64
+	prev     float64
+	hasPrev  bool
+	weights  []float64 // prefix sums of the block weights
+	max      float64   // the largest block weight
+}
+
+const maxPlacementTries = 1000
+
+func newPlacer(opt *Options) *placer {
+	p := placer{
+		mode:   opt.Placement,
+		center: opt.PlacementCenter,
+		spread: opt.PlacementSpread,
+	}
+	if p.mode == PlacementCluster {
+		p.clusters = make([]float64, opt.PlacementClusters)
+		for i := range p.clusters {
+			p.clusters[i] = rand.Float64()
+		}
+	}
+	return &p
+}
+
+// setScores makes the placer prefer detailed or flat blocks
+func (p *placer) setScores(scores []float64, flat bool, strength float64) {
+	if strength == 0 {
+		strength = 1
+	}
+	p.weights = make([]float64, len(scores)+1)
+	p.max = 0
+	for i, s := range scores {
+		if flat {
+			s = 1 - s
+		}
+		w := pow(s, strength)
+		p.weights[i+1] = p.weights[i] + w
+		if w > p.max {
+			p.max = w
+		}
+	}
+}
+
+func (p *placer) weight(start, segBlocks int) float64 {
+	return p.weights[start+segBlocks] - p.weights[start]
+}
+
+// place returns the first block of a segment of segBlocks blocks
+func (p *placer) place(blocks, segBlocks int) int {
+	start := p.sample(blocks, segBlocks)
+	if p.weights != nil {
+		// Rejection sampling. No segment weighs more than segBlocks of the heaviest blocks
+		max := float64(segBlocks) * p.max
+		for i := 0; i < maxPlacementTries && rand.Float64()*max > p.weight(start, segBlocks); i++ {
+			start = p.sample(blocks, segBlocks)
+		}
+	}
+
+	p.prev = (float64(start) + float64(segBlocks)/2) / float64(blocks)
+	p.hasPrev = true
+	return start
+}
+
+func (p *placer) sample(blocks, segBlocks int) int {
+	var c float64
+	switch p.mode {
+	case PlacementGaussian:
+		c = p.center + float64(normal()*p.spread)
+	case PlacementTop:
+		c = math.Abs(normal() * p.spread)
+	case PlacementBottom:
+		c = 1 - math.Abs(normal()*p.spread)
+	case PlacementCluster:
+		c = p.clusters[rand.Intn(len(p.clusters))] + float64(normal()*p.spread)
+	case PlacementFollow:
+		if !p.hasPrev {
+			return rand.Intn(blocks - segBlocks + 1)
+		}
+		c = p.prev + float64(normal()*p.spread)
+	default:
+		return rand.Intn(blocks - segBlocks + 1)
+	}
+
+	// c is the segment center
+	start := int(math.Floor(float64(c*float64(blocks)) - float64(segBlocks)/2 + 0.5))
+	if start < 0 {
+		start = 0
+	} else if start > blocks-segBlocks {
+		start = blocks - segBlocks
+	}
+	return start
+}
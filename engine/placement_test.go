@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlacement(t *testing.T) {
+	const blocks = 100
+	for name, mode := range placementNames {
+		p := newPlacer(&Options{
+			Placement:         mode,
+			PlacementCenter:   0.5,
+			PlacementSpread:   0.5,
+			PlacementClusters: 3,
+		})
+		for i := 0; i < 1000; i++ {
+			segBlocks := 1 + i%blocks
+			if start := p.place(blocks, segBlocks); start < 0 || start+segBlocks > blocks {
+				t.Fatalf("%s: segment %d+%d is out of range", name, start, segBlocks)
+			}
+		}
+	}
+}
+
+func TestPlacementShift(t *testing.T) {
+	const (
+		blocks = 100
+		n      = 2000
+	)
+	for _, tt := range []struct {
+		mode     int
+		min, max float64 // Mean segment start
+		jump     float64 // Largest mean distance between consecutive segments
+	}{
+		{PlacementUniform, 45, 55, 100},
+		{PlacementGaussian, 17, 23, 100},
+		{PlacementTop, 0, 12, 100},
+		{PlacementBottom, 87, 99, 100},
+		{PlacementCluster, 0, 99, 5},
+		{PlacementFollow, 0, 99, 15},
+	} {
+		p := newPlacer(&Options{
+			Placement:         tt.mode,
+			PlacementCenter:   0.2,
+			PlacementSpread:   0.1,
+			PlacementClusters: 1,
+		})
+		if tt.mode == PlacementCluster {
+			p.spread = 0.01
+		}
+		var sum, jump float64
+		prev := p.place(blocks, 1)
+		for i := 0; i < n; i++ {
+			start := p.place(blocks, 1)
+			sum += float64(start)
+			jump += math.Abs(float64(start - prev))
+			prev = start
+		}
+		if mean := sum / n; mean < tt.min || mean > tt.max {
+			t.Errorf("mode %d: mean start %.1f is out of %.0f..%.0f", tt.mode, mean, tt.min, tt.max)
+		}
+		if jump /= n; jump > tt.jump {
+			t.Errorf("mode %d: mean distance between segments %.1f is over %.0f", tt.mode, jump, tt.jump)
+		}
+	}
+}
+
+func TestContentPlacement(t *testing.T) {
+	const blocks = 100
+	// Flat first half, detailed second half
+	scores := make([]float64, blocks)
+	for i := blocks / 2; i < blocks; i++ {
+		scores[i] = 1
+	}
+	for _, flat := range []bool{false, true} {
+		p := newPlacer(&Options{})
+		// Zero strength is the default one
+		p.setScores(scores, flat, 0)
+		for i := 0; i < 1000; i++ {
+			if start := p.place(blocks, 1); start < blocks/2 != flat {
+				t.Fatalf("flat: %t: segment is placed at %d", flat, start)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+)
+
+// Smear. A row or column of the block is repeated over the rest of the block like a stuck decoder does.
+// In the segment mode the line spans the block row or column of the segment and is repeated across the segment.
+// The line is picked at a fixed position or as the brightest or the darkest one. The streak may fade back
+// to the source with the distance from the line
+
+const (
+	smearPos = iota
+	smearBright
+	smearDark
+	smearNumModes
+)
+
+var smearModeNames = [...]string{
+	smearPos:    "pos",
+	smearBright: "bright",
+	smearDark:   "dark",
+}
+
+type filterSmear struct {
+	mode     uint8
+	pos      uint16 // Line position relative to the block size, 1/0x10000 units
+	vertical bool   // Repeat a column instead of a row
+	reverse  bool   // Smear towards the start of the block
+	fade     int    // Distance the streak fades out over, zero for none
+	seg      bool   // Smear across the segment
+}
+
+func (f filterSmear) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	f.filterBlock(bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+// line returns the index of the line to repeat. pix is the w*h block
+func (f filterSmear) line(pix []NRGBA, w, h int) int {
+	lines, ln, step, stride := h, w, 1, w
+	if f.vertical {
+		lines, ln, step, stride = w, h, w, 1
+	}
+	if f.mode == smearPos {
+		return int(f.pos) * lines >> 16
+	}
+	best, bestSum := 0, uint64(0)
+	for i := 0; i < lines; i++ {
+		var sum uint64
+		for j := 0; j < ln; j++ {
+			sum += uint64(lumaNRGBA(pix[i*stride+j*step]))
+		}
+		if i == 0 || f.mode == smearBright && sum > bestSum || f.mode == smearDark && sum < bestSum {
+			best, bestSum = i, sum
+		}
+	}
+	return best
+}
+
+func (f filterSmear) filterBlock(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	applyAreaFilter(f.smearArea, dst, dr, src, sp, op, dr.Dx()*dr.Dy())
+}
+
+// smearArea repeats the line over the w*h area. The line itself stays intact so the pixels are replaced in place
+func (f filterSmear) smearArea(pix []NRGBA, w, h int) {
+	c := f.line(pix, w, h)
+	for y := 0; y < h; y++ {
+		row := pix[y*w : (y+1)*w]
+		for x := range row {
+			i, lp := y, x+c*w
+			if f.vertical {
+				i, lp = x, y*w+c
+			}
+			dist := i - c
+			if f.reverse {
+				dist = -dist
+			}
+			if dist <= 0 {
+				continue
+			}
+			v := pix[lp]
+			if f.fade > 0 {
+				if dist >= f.fade {
+					continue
+				}
+				a := uint32((f.fade - dist) * 0xffff / f.fade)
+				for k := range v {
+					v[k] = lerp16(row[x][k], v[k], a)
+				}
+			}
+			row[x] = v
+		}
+	}
+}
+
+// segment smears a row down the block columns of the segment and a column along its block rows
+func (f filterSmear) segment() (bool, bool) {
+	return f.seg, !f.vertical
+}
+
+func (f filterSmear) filterBand(dst *buffer, dr image.Rectangle, src *buffer, seg image.Rectangle, op Operation) {
+	applyAreaFilter(f.smearArea, dst, dr, src, dr.Min, op, bandReserve(dr, src.Rect, !f.vertical))
+}
+
+func (f filterSmear) String() string {
+	return fmt.Sprintf("smear:{m:%s,p:%d,v:%t,r:%t,f:%d,s:%t}", smearModeNames[f.mode], f.pos, f.vertical, f.reverse, f.fade, f.seg)
+}
+
+func newFilterSmear(opt *FilterOptions) Filter {
+	f := filterSmear{
+		mode:     uint8(rand.Intn(smearNumModes)),
+		vertical: rand.Intn(2) == 1,
+		reverse:  rand.Intn(2) == 1,
+		seg:      rand.Intn(2) == 1,
+	}
+	if f.mode == smearPos {
+		f.pos = uint16(rand.Intn(0x10000))
+	}
+	if rand.Intn(2) == 1 {
+		bs := opt.BlockSize
+		if bs < 1 {
+			bs = 1
+		}
+		f.fade = 1 + rand.Intn(2*bs)
+	}
+	return f
+}
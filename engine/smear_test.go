@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"image"
+	"testing"
+)
+
+func TestSmear(t *testing.T) {
+	// The gradient pixel at x, y is {x*7, y*5, x^y}. The last row is the brightest and the first column
+	// the darkest one. The fade weights of the tested pixels are 0.6
+	r := image.Rect(0, 0, 24, 20)
+	for _, tt := range []struct {
+		f        filterSmear
+		x, y     int
+		expected NRGBA
+	}{
+		{filterSmear{mode: smearPos, pos: 0x8000}, 5, 15, rgb8(35, 50, 15)},
+		{filterSmear{mode: smearPos, pos: 0x8000}, 5, 9, rgb8(35, 45, 12)},
+		{filterSmear{mode: smearPos, pos: 0x4000, vertical: true, reverse: true}, 2, 7, rgb8(42, 35, 1)},
+		{filterSmear{mode: smearPos, pos: 0x4000, vertical: true, reverse: true}, 8, 7, rgb8(56, 35, 15)},
+		{filterSmear{mode: smearBright, reverse: true, fade: 5}, 4, 17, NRGBA{7196, 23387, 5705, 0xffff}},
+		{filterSmear{mode: smearBright, reverse: true, fade: 5}, 4, 14, rgb8(28, 70, 10)},
+		{filterSmear{mode: smearDark, vertical: true, fade: 30}, 12, 3, NRGBA{8635, 3855, 2004, 0xffff}},
+		{filterSmear{mode: smearDark, vertical: true, fade: 30}, 0, 3, rgb8(0, 15, 3)},
+	} {
+		_, dst := filterGradient(tt.f, r, r.Dx())
+		if c := pixelAt(dst, tt.x, tt.y); c != tt.expected {
+			t.Errorf("%v: pixel %d,%d is %v, expected %v", tt.f, tt.x, tt.y, c, tt.expected)
+		}
+	}
+
+	// 3x3 blocks of 8 pixels, the segment leaves out the first and the last block. The first row
+	// of the segment column is repeated down to its end
+	src := new(buffer).reuse(r, 8)
+	src.loadImage(gradient(r), r)
+	for _, tt := range []struct {
+		x, y          int
+		block, expect NRGBA
+	}{
+		{5, 15, rgb8(35, 40, 13), rgb8(35, 40, 13)},
+		{12, 15, rgb8(84, 40, 4), rgb8(84, 0, 12)},
+		{20, 12, rgb8(140, 40, 28), rgb8(140, 0, 20)},
+		{5, 3, NRGBA{}, NRGBA{}},
+	} {
+		for _, seg := range []bool{false, true} {
+			p := pass{bounds: r, blockSize: 8, blocksX: 3, blocks: 9, segStart: 1, segBlocks: 7, chunk: 1,
+				filter: filterSmear{mode: smearPos, seg: seg}, op: opReplace{}, first: true, src: src, dst: new(buffer).reuse(r, 8)}
+			var e Engine
+			if seg {
+				e.runSegment(&p, true, new(buffer).reuse(r, 8))
+			} else {
+				e.workers.run(&p)
+			}
+			expected := tt.block
+			if seg {
+				expected = tt.expect
+			}
+			if c := pixelAt(p.dst, tt.x, tt.y); c != expected {
+				t.Errorf("%v: pixel %d,%d is %v, expected %v", p.filter, tt.x, tt.y, c, expected)
+			}
+		}
+	}
+}
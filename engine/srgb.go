@@ -0,0 +1,94 @@
+package engine
+
+import "sync"
+
+// sRGB transfer function tables. They are built on first use with the deterministic pow
+var (
+	srgbOnce     sync.Once
+	srgbToLinear []float32 // 16 bit sRGB to linear light in 0..1
+	linearIndex  []uint16  // first srgbToLinear entry of each of the linearBuckets ranges
+
+	// 16 bit to 16 bit conversions for processing in linear light
+	srgbToLinear16 []uint16
+	linear16ToSRGB []uint16
+)
+
+const linearBuckets = 0x10000
+
+func initSRGB() {
+	srgbToLinear = make([]float32, 0x10000)
+	for i := range srgbToLinear {
+		v := float64(i) / 0xffff
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = float32(v)
+	}
+
+	linearIndex = make([]uint16, linearBuckets+1)
+	i := 0
+	for k := range linearIndex {
+		for i < 0xffff && srgbToLinear[i] < float32(k)/linearBuckets {
+			i++
+		}
+		linearIndex[k] = uint16(i)
+	}
+
+	srgbToLinear16 = make([]uint16, 0x10000)
+	linear16ToSRGB = make([]uint16, 0x10000)
+	for i, v := range srgbToLinear {
+		srgbToLinear16[i] = uint16(to16(float64(v)))
+	}
+	for i := range linear16ToSRGB {
+		linear16ToSRGB[i] = uint16(delinearizeFast(float64(i) / 0xffff))
+	}
+	// 16 bit linear light is coarse near black. Pin the 8 bit values so they survive the round trip
+	for v := 0; v < 0x100; v++ {
+		linear16ToSRGB[srgbToLinear16[v*0x101]] = uint16(v * 0x101)
+	}
+}
+
+// linearTables returns the tables converting 16 bit sRGB to 16 bit linear light and back
+func linearTables() (toLinear, toSRGB []uint16) {
+	srgbOnce.Do(initSRGB)
+	return srgbToLinear16, linear16ToSRGB
+}
+
+// linearize returns the linear light value of the 16 bit sRGB component
+func linearize(v uint32) float64 {
+	srgbOnce.Do(initSRGB)
+	return float64(srgbToLinear[v&0xffff])
+}
+
+// delinearize returns the nearest 16 bit sRGB value of the linear light value
+func delinearize(v float64) uint32 {
+	srgbOnce.Do(initSRGB)
+	return delinearizeFast(v)
+}
+
+func delinearizeFast(v float64) uint32 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	f := float32(v)
+	// Binary search for the first entry not less than f within the bucket
+	k := int(float64(v * linearBuckets))
+	i, j := int(linearIndex[k]), int(linearIndex[k+1])
+	for i < j {
+		m := (i + j) >> 1
+		if srgbToLinear[m] < f {
+			i = m + 1
+		} else {
+			j = m
+		}
+	}
+	if i > 0 && f-srgbToLinear[i-1] < srgbToLinear[i]-f {
+		i--
+	}
+	return uint32(i)
+}
@@ -0,0 +1,27 @@
+package engine
+
+import "testing"
+
+func TestSRGB(t *testing.T) {
+	for v := uint32(0); v <= 0xffff; v++ {
+		if d := delinearize(linearize(v)); d != v {
+			t.Fatalf("%x: round trip %x", v, d)
+		}
+	}
+}
+
+func TestLinearTables(t *testing.T) {
+	toLinear, toSRGB := linearTables()
+	// 8 bit values must survive the trip
+	for v := 0; v < 0x100; v++ {
+		if d := toSRGB[toLinear[v*0x101]] >> 8; int(d) != v {
+			t.Errorf("%x: round trip %x", v, d)
+		}
+	}
+	// A step of 16 bit linear light is up to 13 units of sRGB near black
+	for v := 0; v <= 0xffff; v++ {
+		if d := int(toSRGB[toLinear[v]]) - v; d < -13 || d > 13 {
+			t.Fatalf("%x: round trip %x", v, d)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"os"
+	"sync"
+)
+
+// minStripes is the number of stripes a store tries to keep in memory at once
+const minStripes = 8
+
+// stripeStore keeps the rows of a buffer in a temporary file and caches a limited number
+// of horizontal stripes in memory. The cache is two way set associative with a lock per set
+// so the workers only wait for each other when they touch the stripes of the same set.
+// I/O errors are sticky and reported by err
+type stripeStore struct {
+	file   *os.File
+	rowLen int
+	height int
+	rows   int // rows per stripe
+	sets   []stripeSet
+	errMtx sync.Mutex
+	err    error
+}
+
+type stripeSet struct {
+	mtx  sync.Mutex
+	ways [2]stripe
+	mru  int
+}
+
+type stripe struct {
+	n     int
+	pix   []uint8
+	dirty bool
+}
+
+func newStripeStore(rowLen, height int, budget int64) (*stripeStore, error) {
+	f, err := os.CreateTemp("", "gltihc")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(rowLen) * int64(height)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	rows := int(budget / int64(rowLen*minStripes))
+	if rows < 1 {
+		rows = 1
+	}
+	if rows > height {
+		rows = height
+	}
+	max := int(budget / int64(rowLen*rows))
+	if max < 2 {
+		max = 2
+	}
+	s := stripeStore{
+		file:   f,
+		rowLen: rowLen,
+		height: height,
+		rows:   rows,
+		sets:   make([]stripeSet, max/2),
+	}
+	for i := range s.sets {
+		s.sets[i].ways[0].n, s.sets[i].ways[1].n = -1, -1
+	}
+	return &s, nil
+}
+
+func (s *stripeStore) close() error {
+	err := s.file.Close()
+	if e := os.Remove(s.file.Name()); err == nil {
+		err = e
+	}
+	s.sets = nil
+	return err
+}
+
+// set returns the cache set holding the row y
+func (s *stripeStore) set(y int) *stripeSet {
+	return &s.sets[y/s.rows%len(s.sets)]
+}
+
+// row returns the row y. The slice stays valid until its stripe gets evicted. The most recently used stripe
+// of a set is never evicted by the next call so two rows can be accessed at once. The caller must either
+// serialize the access or hold the lock of the set returned by set(y) while using the row
+func (s *stripeStore) row(y int, write bool) []uint8 {
+	n := y / s.rows
+	st := s.lookup(s.set(y), n)
+	if write {
+		st.dirty = true
+	}
+	i := (y - n*s.rows) * s.rowLen
+	return st.pix[i : i+s.rowLen : i+s.rowLen]
+}
+
+func (s *stripeStore) lookup(set *stripeSet, n int) *stripe {
+	for i := range set.ways {
+		if set.ways[i].n == n {
+			set.mru = i
+			return &set.ways[i]
+		}
+	}
+
+	set.mru ^= 1
+	st := &set.ways[set.mru]
+	size := s.rows * s.rowLen
+	if st.pix == nil {
+		st.pix = make([]uint8, size)
+	} else if st.dirty {
+		s.write(st)
+	}
+
+	// The last stripe may be shorter
+	if rem := (s.height - n*s.rows) * s.rowLen; rem < size {
+		size = rem
+	}
+	st.n, st.dirty = n, false
+	if _, err := s.file.ReadAt(st.pix[:size], int64(n)*int64(s.rows*s.rowLen)); err != nil {
+		s.setErr(err)
+	}
+	return st
+}
+
+func (s *stripeStore) write(st *stripe) {
+	size := s.rows * s.rowLen
+	if rem := (s.height - st.n*s.rows) * s.rowLen; rem < size {
+		size = rem
+	}
+	if _, err := s.file.WriteAt(st.pix[:size], int64(st.n)*int64(s.rows*s.rowLen)); err != nil {
+		s.setErr(err)
+	}
+	st.dirty = false
+}
+
+func (s *stripeStore) setErr(err error) {
+	s.errMtx.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.errMtx.Unlock()
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+)
+
+// Scanline displacement. Every row is shifted horizontally by a sine wave, by smooth value noise or by random
+// steps. The rows are counted from the top of the image so the neighbouring blocks line up, from the top
+// of the block so every block wobbles the same way, or from the top of the segment. The pixels shifted in come
+// from outside of the block and either wrap around or repeat the edge pixels of the image, or of the segment
+// in the segment mode
+
+const (
+	waveSine = iota
+	waveNoise
+	waveStep
+	waveNumShapes
+)
+
+var waveShapeNames = [...]string{
+	waveSine:  "sin",
+	waveNoise: "noise",
+	waveStep:  "step",
+}
+
+const (
+	waveImage = iota
+	waveBlock
+	waveSegment
+	waveNumModes
+)
+
+var waveModeNames = [...]string{
+	waveImage:   "img",
+	waveBlock:   "block",
+	waveSegment: "seg",
+}
+
+type filterWave struct {
+	shape  uint8
+	amp    int    // Largest offset in pixels
+	period int    // Rows per sine period or between the random values
+	seed   uint32 // Sine phase in 1/0x10000 turns or the random values seed
+	mode   uint8  // Where the rows are counted from
+	wrap   bool
+}
+
+func hash32(x uint32) uint32 {
+	x ^= x >> 16
+	x *= 0x7feb352d
+	x ^= x >> 15
+	x *= 0x846ca68b
+	x ^= x >> 16
+	return x
+}
+
+// knot returns the random offset number k
+func (f filterWave) knot(k int) int {
+	v := int(hash32(f.seed^uint32(k)*0x9e3779b9) >> 16)
+	return v*(2*f.amp+1)>>16 - f.amp
+}
+
+// offset returns the shift of the row y
+func (f filterWave) offset(y int) int {
+	switch f.shape {
+	case waveSine:
+		v := float64(float64(f.amp) * sinTurn(float64(y)/float64(f.period)+float64(f.seed&0xffff)/0x10000))
+		if v < 0 {
+			return -int(0.5 - v)
+		}
+		return int(v + 0.5)
+	case waveNoise:
+		// Smoothstep between the knots
+		k, t := y/f.period, int64(y%f.period)*0x10000/int64(f.period)
+		t = t * t * (3*0x10000 - 2*t) >> 32
+		v0, v1 := int64(f.knot(k)), int64(f.knot(k+1))
+		return int(v0 + ((v1-v0)*t+0x8000)>>16)
+	default:
+		return f.knot(y / f.period)
+	}
+}
+
+func (f filterWave) Apply(dst *image.NRGBA64, dr image.Rectangle, src *image.NRGBA64, sp image.Point, op Operation) {
+	f.filterBlock(bufferNRGBA64(dst), dr, bufferNRGBA64(src), sp, op)
+}
+
+func (f filterWave) filterBlock(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation) {
+	y0 := src.Rect.Min.Y
+	if f.mode == waveBlock {
+		y0 = sp.Y
+	}
+	f.displace(dst, dr, src, sp, op, y0, src.Rect)
+}
+
+func (f filterWave) segment() (bool, bool) {
+	return f.mode == waveSegment, false
+}
+
+func (f filterWave) filterBand(dst *buffer, dr image.Rectangle, src *buffer, seg image.Rectangle, op Operation) {
+	f.displace(dst, dr, src, dr.Min, op, seg.Min.Y, image.Rect(dr.Min.X, src.Rect.Min.Y, dr.Max.X, src.Rect.Max.Y))
+}
+
+// displace shifts the rows counted from the source row y0. The samples are clamped or wrapped to edges
+func (f filterWave) displace(dst *buffer, dr image.Rectangle, src *buffer, sp image.Point, op Operation, y0 int, edges image.Rectangle) {
+	buf := spanPool.Get().(*spanBuffers)
+	defer spanPool.Put(buf)
+	_, replace := op.(opReplace)
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		sy := sp.Y + y - dr.Min.Y
+		off := f.offset(sy - y0)
+		for x := dr.Min.X; x < dr.Max.X; x += spanLen {
+			n := dr.Max.X - x
+			if n > spanLen {
+				n = spanLen
+			}
+			s := buf.s[:n]
+			src.loadEdge(s, sp.X+x-dr.Min.X-off, sy, edges, f.wrap)
+			storeOp(dst, x, y, s, buf.d[:n], op, replace)
+		}
+	}
+}
+
+func (f filterWave) String() string {
+	return fmt.Sprintf("wave:{s:%s,a:%d,p:%d,seed:%d,m:%s,w:%t}", waveShapeNames[f.shape], f.amp, f.period, f.seed,
+		waveModeNames[f.mode], f.wrap)
+}
+
+func newFilterWave(opt *FilterOptions) Filter {
+	bs := opt.BlockSize
+	if bs < 1 {
+		bs = 1
+	}
+	minAmp, maxAmp := opt.MinWaveAmp, opt.MaxWaveAmp
+	if maxAmp == 0 {
+		minAmp, maxAmp = 1, bs
+	}
+	f := filterWave{
+		shape: uint8(rand.Intn(waveNumShapes)),
+		amp:   minAmp + rand.Intn(maxAmp-minAmp+1),
+		mode:  uint8(rand.Intn(waveNumModes)),
+		wrap:  opt.WrapEdges,
+	}
+	if opt.MaxWaveFreq == 0 {
+		f.period = 2 + rand.Intn(4*bs)
+	} else {
+		freq := opt.MinWaveFreq + float64(rand.Float64()*(opt.MaxWaveFreq-opt.MinWaveFreq))
+		f.period = int(1/freq + 0.5)
+		if f.period < 1 {
+			f.period = 1
+		}
+	}
+	if f.shape == waveSine {
+		f.seed = uint32(rand.Intn(0x10000))
+	} else {
+		f.seed = rand.Uint32()
+	}
+	return f
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"image"
+	"testing"
+)
+
+func TestWave(t *testing.T) {
+	// The gradient pixel at x, y is {x*7, y*5, x^y}. The sine with the period of 12 rows shifts the rows 1, 3 and 7
+	// by 5, 10 and -5 pixels
+	r := image.Rect(0, 0, 40, 30)
+	sine := filterWave{shape: waveSine, amp: 10, period: 12}
+	wrapped := sine
+	wrapped.wrap = true
+	block := sine
+	block.mode = waveBlock
+	for _, tt := range []struct {
+		f        filterWave
+		x, y     int
+		expected NRGBA
+	}{
+		{sine, 20, 3, rgb8(70, 15, 9)},
+		{sine, 3, 3, rgb8(0, 15, 3)},
+		{wrapped, 3, 3, rgb8(231, 15, 34)},
+		{sine, 20, 19, rgb8(175, 95, 10)},
+		{block, 20, 19, rgb8(70, 95, 25)},
+	} {
+		_, dst := filterGradient(tt.f, r, 16)
+		if c := pixelAt(dst, tt.x, tt.y); c != tt.expected {
+			t.Errorf("%v: pixel %d,%d is %v, expected %v", tt.f, tt.x, tt.y, c, tt.expected)
+		}
+	}
+
+	// The segment mode counts the rows from the top of the segment and takes the samples from the band
+	seg := sine
+	seg.mode = waveSegment
+	band, segRect := image.Rect(16, 16, 40, 24), image.Rect(0, 13, 40, 30)
+	for _, wrapEdges := range []bool{false, true} {
+		seg.wrap = wrapEdges
+		src := new(buffer).reuse(r, 8)
+		src.loadImage(gradient(r), r)
+		dst := new(buffer).reuse(r, 8)
+		seg.filterBand(dst, band, src, segRect, opReplace{})
+		expected := rgb8(112, 80, 0)
+		if wrapEdges {
+			expected = rgb8(238, 80, 50)
+		}
+		if c := pixelAt(dst, 20, 16); c != expected {
+			t.Errorf("%v: pixel 20,16 is %v, expected %v", seg, c, expected)
+		}
+		if c := pixelAt(dst, 30, 16); c != rgb8(140, 80, 4) {
+			t.Errorf("%v: pixel 30,16 is %v", seg, c)
+		}
+		if c := pixelAt(dst, 15, 16); c != (NRGBA{}) {
+			t.Errorf("%v: pixel 15,16 outside of the band is %v", seg, c)
+		}
+	}
+
+	// The sine reaches the amplitude and the noise passes through the knots
+	f := filterWave{shape: waveSine, amp: 10, period: 8}
+	if f.offset(0) != 0 || f.offset(2) != 10 || f.offset(6) != -10 {
+		t.Errorf("%v: offsets %d, %d, %d", f, f.offset(0), f.offset(2), f.offset(6))
+	}
+	f = filterWave{shape: waveNoise, amp: 10, period: 8, seed: 5}
+	for k := 0; k < 4; k++ {
+		if f.offset(k*8) != f.knot(k) {
+			t.Errorf("%v: offset %d at knot %d", f, f.offset(k*8), f.knot(k))
+		}
+	}
+
+	// The ranges from the options. Periods of 4 to 10 rows
+	fo := FilterOptions{BlockSize: 16, MinWaveAmp: 3, MaxWaveAmp: 5, MinWaveFreq: 0.1, MaxWaveFreq: 0.25}
+	for i := 0; i < 100; i++ {
+		f := newFilterWave(&fo).(filterWave)
+		if f.amp < 3 || f.amp > 5 || f.period < 4 || f.period > 10 {
+			t.Fatalf("%v is out of range", f)
+		}
+	}
+}
+
+func TestWaveOptions(t *testing.T) {
+	img := gradient(image.Rect(0, 0, 32, 32))
+	for _, tt := range []struct {
+		amp  [2]int
+		freq [2]float64
+		ok   bool
+	}{
+		{ok: true},
+		{amp: [2]int{3, 5}, freq: [2]float64{0.1, 0.25}, ok: true},
+		{amp: [2]int{0, 5}, ok: true},
+		{amp: [2]int{3, 0}},
+		{amp: [2]int{5, 3}},
+		{freq: [2]float64{0.1, 0}},
+		{freq: [2]float64{0, 0.25}},
+		{freq: [2]float64{0.25, 0.1}},
+	} {
+		opt := Options{
+			MinIterations:  1,
+			MaxIterations:  1,
+			BlockSize:      8,
+			MinSegmentSize: 0.5,
+			MaxSegmentSize: 0.5,
+			MinFilters:     1,
+			MaxFilters:     1,
+			Filters:        []string{"wave"},
+			MinWaveAmp:     tt.amp[0],
+			MaxWaveAmp:     tt.amp[1],
+			MinWaveFreq:    tt.freq[0],
+			MaxWaveFreq:    tt.freq[1],
+		}
+		if _, err := opt.Apply(img); (err == nil) != tt.ok {
+			t.Errorf("amplitude %v, frequency %v: %v", tt.amp, tt.freq, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// chunksPerThread controls the granularity of the block queue
+const chunksPerThread = 8
+
+// workers is a persistent pool of goroutines sharing the segment blocks in chunks.
+// The calling goroutine takes part in the processing so n is one less than the number of threads
+type workers struct {
+	n    int
+	jobs chan *pass
+	wg   sync.WaitGroup
+}
+
+func newWorkers(n int) *workers {
+	w := workers{
+		n:    n,
+		jobs: make(chan *pass),
+	}
+	for i := 0; i < n; i++ {
+		go w.loop()
+	}
+	return &w
+}
+
+func (w *workers) loop() {
+	for p := range w.jobs {
+		p.drain()
+		w.wg.Done()
+	}
+}
+
+func (w *workers) close() {
+	close(w.jobs)
+}
+
+// run processes the pass using the calling goroutine and as many workers as there are spare chunks
+func (w *workers) run(p *pass) {
+	start, end := p.jobs()
+	p.next = int32(start)
+	helpers := (end-start+p.chunk-1)/p.chunk - 1
+	if w == nil {
+		helpers = 0
+	} else if helpers > w.n {
+		helpers = w.n
+	}
+
+	if helpers > 0 {
+		w.wg.Add(helpers)
+		for i := 0; i < helpers; i++ {
+			w.jobs <- p
+		}
+	}
+	p.drain()
+	if helpers > 0 {
+		w.wg.Wait()
+	}
+}
+
+// drain takes chunks from the queue until it's empty
+func (p *pass) drain() {
+	_, end := p.jobs()
+	for {
+		b := int(atomic.AddInt32(&p.next, int32(p.chunk))) - p.chunk
+		if b >= end {
+			return
+		}
+		ln := p.chunk
+		if ln > end-b {
+			ln = end - b
+		}
+		p.run(b, ln)
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import "image/color"
+
+// JFIF YCbCr with 16 bit components and 24 bit fixed point coefficients. Chroma is centered at 0x8000
+
+func clamp16(v int64) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xffff {
+		return 0xffff
+	}
+	return uint32(v)
+}
+
+func rgbToYCC(r, g, b uint32) (uint32, uint32, uint32) {
+	r1, g1, b1 := int64(r), int64(g), int64(b)
+	y := 5016388*r1 + 9848225*g1 + 1912603*b1
+	// Cb = (B-Y)/1.772, Cr = (R-Y)/1.402
+	cb := ((b1<<24-y)>>4*591747 + 0x8000<<40 + 1<<39) >> 40
+	cr := ((r1<<24-y)>>4*747914 + 0x8000<<40 + 1<<39) >> 40
+	return uint32((y + 1<<23) >> 24), clamp16(cb), clamp16(cr)
+}
+
+func yccToRGB(y, cb, cr uint32) (uint32, uint32, uint32) {
+	y1 := int64(y) << 24
+	cb1, cr1 := int64(cb)-0x8000, int64(cr)-0x8000
+	r := (y1 + 23521657*cr1 + 1<<23) >> 24
+	g := (y1 - 5773649*cb1 - 11981219*cr1 + 1<<23) >> 24
+	b := (y1 + 29729227*cb1 + 1<<23) >> 24
+	return clamp16(r), clamp16(g), clamp16(b)
+}
+
+// Legacy 8 bit conversions kept for reproducibility
+
+func rgbToYCC8(r, g, b uint32) (uint8, uint8, uint8) {
+	return color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+}
+
+func ycc8ToRGB(y, cb, cr uint8) (uint32, uint32, uint32) {
+	r8, g8, b8 := color.YCbCrToRGB(y, cb, cr)
+	return uint32(r8) * 0x101, uint32(g8) * 0x101, uint32(b8) * 0x101
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestYCC(t *testing.T) {
+	for r := uint32(0); r <= 0xffff; r += 0x1111 {
+		for g := uint32(0); g <= 0xffff; g += 0x0f0f {
+			for b := uint32(0); b <= 0xffff; b += 0x0707 {
+				y, cb, cr := rgbToYCC(r, g, b)
+				y8, cb8, cr8 := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+				if d := int(y>>8) - int(y8); d < -1 || d > 1 {
+					t.Fatalf("%x,%x,%x: Y %x, expected %x", r, g, b, y, y8)
+				}
+				if d := int(cb>>8) - int(cb8); d < -1 || d > 1 {
+					t.Fatalf("%x,%x,%x: Cb %x, expected %x", r, g, b, cb, cb8)
+				}
+				if d := int(cr>>8) - int(cr8); d < -1 || d > 1 {
+					t.Fatalf("%x,%x,%x: Cr %x, expected %x", r, g, b, cr, cr8)
+				}
+
+				// Round trip must keep 16 bit precision
+				r1, g1, b1 := yccToRGB(y, cb, cr)
+				for _, d := range []int{int(r1) - int(r), int(g1) - int(g), int(b1) - int(b)} {
+					if d < -1 || d > 1 {
+						t.Fatalf("%x,%x,%x: round trip %x,%x,%x", r, g, b, r1, g1, b1)
+					}
+				}
+			}
+		}
+	}
+}
+
+var yccSink uint32
+
+func BenchmarkYCC(b *testing.B) {
+	pix := make([]NRGBA, 4096)
+	for i := range pix {
+		v := uint32(i) * 0x9e3779b9
+		pix[i] = NRGBA{v >> 16, v & 0xffff, (v >> 8) & 0xffff, 0xffff}
+	}
+
+	b.Run("16", func(b *testing.B) {
+		var sum uint32
+		for i := 0; i < b.N; i++ {
+			c := pix[i&(len(pix)-1)]
+			y, cb, cr := rgbToYCC(c[0], c[1], c[2])
+			r, g, bl := yccToRGB(cr, y, cb)
+			sum += r + g + bl
+		}
+		yccSink = sum
+	})
+
+	b.Run("8", func(b *testing.B) {
+		var sum uint32
+		for i := 0; i < b.N; i++ {
+			c := pix[i&(len(pix)-1)]
+			y, cb, cr := rgbToYCC8(c[0], c[1], c[2])
+			r, g, bl := ycc8ToRGB(cr, y, cb)
+			sum += r + g + bl
+		}
+		yccSink = sum
+	})
+}
@@ -16,6 +16,8 @@ import (
 	_ "golang.org/x/image/webp"
 )
 
+var eng engine.Engine
+
 func processImageFunc(this js.Value, args []js.Value) interface{} {
 	if len(args) != 2 {
 		return nil
@@ -49,6 +51,16 @@ func processImageFunc(this js.Value, args []js.Value) interface{} {
 		maxH = prop.Int()
 	}
 
+	// Zero is a valid opacity so the range only applies when given
+	if prop := o.Get("minOpacity"); prop.Type() == js.TypeNumber {
+		opt.MinOpacity = prop.Float()
+		opt.Opacity = true
+	}
+	if prop := o.Get("maxOpacity"); prop.Type() == js.TypeNumber {
+		opt.MaxOpacity = prop.Float()
+		opt.Opacity = true
+	}
+
 	if prop := o.Get("filters"); prop.Type() == js.TypeObject {
 		opt.Filters = make([]string, prop.Length())
 		for i := range opt.Filters {
@@ -88,7 +100,7 @@ func processImageFunc(this js.Value, args []js.Value) interface{} {
 		sourceImg = scaled
 	}
 
-	resImg, err := opt.Apply(sourceImg)
+	resImg, err := eng.Apply(&opt, sourceImg)
 	if err != nil {
 		log.Error(err)
 		return err.Error()
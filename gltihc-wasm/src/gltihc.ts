@@ -1,7 +1,8 @@
 import "./wasm_exec/wasm_exec.js";
 
 export type Option = "minIterations" | "maxIterations" | "blockSize" | "minSegmentSize" |
-    "maxSegmentSize" | "minFilters" | "maxFilters" | "filters" | "ops" | "maxWidth" | "maxHeight";
+    "maxSegmentSize" | "minFilters" | "maxFilters" | "filters" | "ops" | "maxWidth" | "maxHeight" |
+    "minOpacity" | "maxOpacity";
 ;
 
 export type Options = {
@@ -26,9 +27,11 @@ export class Gltihc {
         filters: null,
         maxFilters: 4,
         maxIterations: 10,
+        maxOpacity: 1,
         maxSegmentSize: 0.2,
         minFilters: 1,
         minIterations: 10,
+        minOpacity: 1,
         minSegmentSize: 0.01,
         ops: null,
         maxHeight: 1024,
@@ -56,6 +56,20 @@ const optionControls: Array<{
             prop: "maxSegmentSize",
             step: 0.01,
         },
+        {
+            label: "Minimum operation opacity",
+            max: 1,
+            min: 0,
+            prop: "minOpacity",
+            step: 0.01,
+        },
+        {
+            label: "Maximum operation opacity",
+            max: 1,
+            min: 0,
+            prop: "maxOpacity",
+            step: 0.01,
+        },
     ];
 
 interface ToggleOptions {
@@ -85,6 +99,22 @@ const filterNames: ToggleOptions = {
     invycc: "Invert YCC component",
     gs: "Gray Scale",
     rasp: "BitRasp",
+    huerot: "Rotate hue",
+    hsv: "Set HSV component",
+    hsl: "Set HSL component",
+    phsv: "Permutate HSV",
+    phsl: "Permutate HSL",
+    qhsva: "Quantize HSVA component",
+    qhsla: "Quantize HSLA component",
+    invhsv: "Invert HSV component",
+    invhsl: "Invert HSL component",
+    qlab: "Quantize OKLab",
+    plab: "Permutate OKLab chroma",
+    mixlab: "OKLab mixer",
+    sort: "Pixel sort",
+    aberr: "Chromatic aberration",
+    wave: "Scanline wave",
+    smear: "Smear",
 };
 
 const operatorNames: ToggleOptions = {
@@ -97,6 +127,47 @@ const operatorNames: ToggleOptions = {
     mulycc: "Multiply YCC",
     xorrgb: "Xor RGB",
     xorycc: "Xor YCC",
+    screen: "Screen",
+    overlay: "Overlay",
+    softlight: "Soft light",
+    hardlight: "Hard light",
+    diff: "Difference",
+    excl: "Exclusion",
+    sub: "Subtract",
+    div: "Divide",
+    darken: "Darken",
+    lighten: "Lighten",
+    dodge: "Color dodge",
+    burn: "Color burn",
+    andrgb: "And RGB",
+    andycc: "And YCC",
+    andraw: "And raw bytes",
+    orrgb: "Or RGB",
+    orycc: "Or YCC",
+    orraw: "Or raw bytes",
+    nandrgb: "Nand RGB",
+    nandycc: "Nand YCC",
+    nandraw: "Nand raw bytes",
+    subrgbm: "Subtract RGB modulo 65536",
+    subyccm: "Subtract YCC modulo 65536",
+    subrawm: "Subtract raw bytes modulo 65536",
+    mulrgbm: "Multiply RGB modulo 65536",
+    mulyccm: "Multiply YCC modulo 65536",
+    mulrawm: "Multiply raw bytes modulo 65536",
+    rotrgb: "Rotate RGB",
+    rotycc: "Rotate YCC",
+    rotraw: "Rotate raw bytes",
+    shlrgb: "Shift left RGB",
+    shlycc: "Shift left YCC",
+    shlraw: "Shift left raw bytes",
+    addhuem: "Add hue modulo 360",
+    mulsat: "Multiply saturation",
+    xorval: "Xor value",
+    hue: "Hue",
+    saturation: "Saturation",
+    color: "Color",
+    luminosity: "Luminosity",
+    addlabm: "Add OKLab modulo",
 };
 
 interface ToggleValues {